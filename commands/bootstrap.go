@@ -81,40 +81,106 @@ func readConfig(all bool, servers []string) ([]config.SectionConfig, error) {
 
 // Connect to server.
 func connectToServer(cfg config.SectionConfig) (*server.Connection, error) {
+	isssh := cfg.Scheme == "sftp" || cfg.Scheme == "ssh"
+
+	// Hosts may be aliases from ~/.ssh/config, which fill
+	// the details the section doesn't set.
+	if isssh {
+		sshcfg := config.NewSsh()
+		if err := sshcfg.Read(); err != nil {
+			return nil, err
+		}
+
+		cfg = sshcfg.Resolve(cfg)
+	}
+
+	// Local directories don't need any credentials and S3
+	// uses access keys instead.
+	islocal := cfg.Scheme == "file" || cfg.Scheme == "s3"
+
 	// Ask interactively for username.
-	if cfg.Username == "" {
+	if cfg.Username == "" && !islocal {
 		cfg.Username = askForUsername(fmt.Sprintf("Enter user for %s: ", cfg.Host))
 		fmt.Println()
 	}
 
-	// Ask interactively for password.
-	if cfg.Password == "" && cfg.Privatekey == "" {
+	// Ask interactively for password. On SSH, an agent holding
+	// keys may be enough to authenticate.
+	if cfg.Password == "" && cfg.Privatekey == "" && !islocal && !(isssh && server.AgentAvailable()) {
 		cfg.Password = askForPassword(fmt.Sprintf("Enter password for %s with user '%s': ", cfg.Host, cfg.Username))
 		fmt.Println()
 	}
 
+	// Protected keys need a passphrase, either from the config,
+	// the environment or asked interactively. Jump hosts may
+	// each have a key, asked for once.
+	var jumpphrase []string
+	if isssh {
+		if cfg.Passphrase == "" {
+			cfg.Passphrase = os.Getenv("STEER_PASSPHRASE")
+		}
+
+		asked := map[string]string{}
+		shared := cfg.Passphrase
+		cfg.Passphrase = keyPassphrase(cfg.Privatekey, shared, asked)
+
+		for _, key := range cfg.Jumpkeys {
+			jumpphrase = append(jumpphrase, keyPassphrase(key, shared, asked))
+		}
+	}
+
 	spin := spinner.New(spinner.CharSets[21], 100*time.Millisecond)
-	spin.Prefix = fmt.Sprintf("Connecting to %s ", cfg.Host)
+	spin.Prefix = fmt.Sprintf("Connecting to %s ", serverName(cfg))
 	spin.Start()
 	defer spin.Stop()
 
 	serverparams := server.Params{
+		Scheme:     cfg.Scheme,
 		Host:       cfg.Host,
 		Port:       cfg.Port,
 		Username:   cfg.Username,
 		Password:   cfg.Password,
 		Privatekey: cfg.Privatekey,
+		Passphrase: cfg.Passphrase,
+		Cacert:     cfg.Cacert,
+		Clientcert: cfg.Clientcert,
+		Clientkey:  cfg.Clientkey,
+		Skipverify: cfg.Skipverify,
+		Knownhosts: cfg.Knownhosts,
+		Hostkey:    cfg.Hostkey,
+		Jumphosts:  cfg.Jumphosts,
+		Jumpkeys:   cfg.Jumpkeys,
+		Jumpphrase: jumpphrase,
+		Bucket:     cfg.Bucket,
+		Region:     cfg.Region,
+		Endpoint:   cfg.Endpoint,
+		Accesskey:  cfg.Accesskey,
+		Secretkey:  cfg.Secretkey,
+		Mimetypes:  cfg.Mimetypes,
+		Cache:      cfg.Cache,
 		Path:       cfg.Path,
 		Maxclients: cfg.Maxclients,
 	}
 
-	var driver server.Driver
-	var err error
-	switch cfg.Scheme {
-	case "ftp":
-		driver, err = server.ConnectFtp(serverparams)
-	case "sftp", "ssh":
-		driver, err = server.ConnectSsh(serverparams)
+	driver, err := connectDriver(serverparams)
+
+	// Hosts that aren't known yet are trusted on first use,
+	// after the user confirms the fingerprint.
+	if unknown, ok := err.(*server.UnknownHostError); ok {
+		spin.Stop()
+		color.Yellow(unknown.Error())
+
+		if !askForConfirmation("Are you sure you want to continue connecting?") {
+			return nil, fmt.Errorf("Host key for %s wasn't accepted.", cfg.Host)
+		}
+
+		if err = unknown.Trust(); err != nil {
+			return nil, err
+		}
+
+		fmt.Println()
+		spin.Start()
+		driver, err = connectDriver(serverparams)
 	}
 
 	if err != nil {
@@ -123,5 +189,50 @@ func connectToServer(cfg config.SectionConfig) (*server.Connection, error) {
 
 	conn := server.Manage(driver)
 
+	// Objects on S3 are replaced whole, and renaming them
+	// means copying.
+	conn.Safeupload = cfg.Safeupload && cfg.Scheme != "s3"
+
 	return conn, nil
 }
+
+// Connect with the driver of the scheme.
+func connectDriver(params server.Params) (server.Driver, error) {
+	switch params.Scheme {
+	case "ftp", "ftps", "ftps-implicit":
+		return server.ConnectFtp(params)
+	case "sftp", "ssh":
+		return server.ConnectSsh(params)
+	case "file":
+		return server.ConnectFile(params)
+	case "s3":
+		return server.ConnectS3(params)
+	case "webdav", "webdavs":
+		return server.ConnectWebdav(params)
+	}
+
+	return nil, fmt.Errorf("Scheme '%s' isn't supported.", params.Scheme)
+}
+
+// Passphrase of a protected key. The one from the config or
+// the environment is used when there's one, otherwise each
+// key is asked for once.
+func keyPassphrase(key, shared string, asked map[string]string) string {
+	if key == "" || !server.KeyNeedsPassphrase(key) {
+		return ""
+	}
+
+	if shared != "" {
+		return shared
+	}
+
+	if passphrase, ok := asked[key]; ok {
+		return passphrase
+	}
+
+	passphrase := askForPassword(fmt.Sprintf("Enter passphrase for key %s: ", key))
+	fmt.Println()
+	asked[key] = passphrase
+
+	return passphrase
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 	"os"
+	"sync"
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 	"github.com/urfave/cli"
@@ -39,6 +40,13 @@ func Deploy(ctx *cli.Context) error {
 
 		isatomic := cfg.Atomic
 
+		// Releases are directories of their own, which files
+		// mapped elsewhere wouldn't be in.
+		if isatomic && len(cfg.Map) > 0 {
+			color.Red("Atomic deployments can't be used with 'map'. Deploy a single directory with 'source' instead.")
+			return
+		}
+
 		// Read the remote revision if it's not a fresh deploy or
 		// an atomic one.
 		if !fresh && !isatomic {
@@ -54,14 +62,19 @@ func Deploy(ctx *cli.Context) error {
 			}
 		}
 
-		vcs, err := git.New(cfg.Branch)
+		vcs, err := git.New(cfg.Branch, cfg.Gitbackend)
 		if err != nil {
 			color.Red(err.Error())
 			return
 		}
 
-		if commit == "" {
-			commit = vcs.RefHead()
+		vcs.ExcludeModules(cfg.Exmodules)
+
+		// Without a specific commit, the branch's latest is
+		// deployed. Servers may be on different branches.
+		head := commit
+		if head == "" {
+			head = vcs.RefHead()
 		}
 
 		// Create the atomic folder when the config option is set.
@@ -72,9 +85,24 @@ func Deploy(ctx *cli.Context) error {
 			fmt.Println()
 		}
 
-		files := vcs.Changes(rev, commit)
-		files = addIncludes(files, cfg.Include)
-		files = removeExcludes(files, cfg.Exclude)
+		files, err := listChanges(vcs, rev, head)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+
+		// Fresh and atomic deploys upload every include.
+		deployed := map[string]string{}
+		if !fresh && !isatomic {
+			deployed = readIncludes(conn)
+		}
+
+		files, includes := prepareFiles(files, cfg, vcs, head, deployed)
+		if !checkLfs(vcs, head, files) {
+			return
+		}
+
+		files = mapFiles(files, cfg.Map)
 
 		// Write a temp file to indicate deployment progress.
 		go func() { createProgressIndicator(conn) }()
@@ -93,32 +121,69 @@ func Deploy(ctx *cli.Context) error {
 		// number of clients read from the config.
 		sem := make(chan bool, cfg.Maxclients)
 
+		// Includes that failed keep their previous hash.
+		var mutex sync.Mutex
+		failed := map[string]bool{}
+
+		deployFile := func(file git.File) {
+			var err error
+
+			switch file.Operation {
+			case git.ADDED, git.COPIED, git.MODIFIED, git.TYPE:
+				err = uploadFile(conn, vcs, cfg, head, file, atomicpath+file.Name)
+				spin.Stop()
+				if err == errSymlinkSkipped {
+					color.Yellow("! %s is a symlink, which the server doesn't support. Skipped.", file.Name)
+					err = nil
+				} else if err != nil {
+					color.Red("× %s couldn't be uploaded", file.Name)
+				} else if perr := setFileMode(conn, cfg, file, atomicpath); perr != nil {
+					color.Yellow("! %s was uploaded, but its permissions couldn't be changed", file.Name)
+				} else {
+					color.Green("✓ %s was uploaded", file.Name)
+				}
+			case git.RENAMED:
+				err = renameFile(conn, vcs, cfg, head, file, atomicpath)
+				spin.Stop()
+				if err != nil {
+					color.Red("× %s couldn't be renamed to %s", file.OldName, file.Name)
+				} else if perr := setFileMode(conn, cfg, file, atomicpath); perr != nil {
+					color.Yellow("! %s was renamed to %s, but its permissions couldn't be changed", file.OldName, file.Name)
+				} else {
+					color.Green("✓ %s was renamed to %s", file.OldName, file.Name)
+				}
+			case git.DELETED:
+				err = conn.Delete(atomicpath + file.Name)
+				spin.Stop()
+				if err != nil {
+					color.Red("× %s couldn't be deleted", file.Name)
+				} else {
+					color.Green("✓ %s was deleted", file.Name)
+				}
+			}
+
+			if err != nil && file.Worktree {
+				mutex.Lock()
+				failed[file.Path()] = true
+				mutex.Unlock()
+			}
+		}
+
 		spin.Prefix = "Starting deploy "
 		spin.Start()
 
-		for _, file := range files {
+		// Renames run one at a time before everything else, as
+		// they read what's on the server.
+		renames, others := orderRenames(files)
+		for _, file := range renames {
+			deployFile(file)
+		}
+
+		for _, file := range others {
 			sem <- true
 
 			go func(file git.File) {
-				switch file.Operation {
-				case git.ADDED, git.COPIED, git.MODIFIED, git.TYPE:
-					err := conn.Upload(file.Name, atomicpath+file.Name)
-					spin.Stop()
-					if err != nil {
-						color.Red("× %s couldn't be uploaded", file.Name)
-					} else {
-						color.Green("✓ %s was uploaded", file.Name)
-					}
-				case git.DELETED:
-					err := conn.Delete(atomicpath + file.Name)
-					spin.Stop()
-					if err != nil {
-						color.Red("× %s couldn't be deleted", file.Name)
-					} else {
-						color.Green("✓ %s was deleted", file.Name)
-					}
-				}
-
+				deployFile(file)
 				<-sem
 			}(file)
 		}
@@ -130,6 +195,14 @@ func Deploy(ctx *cli.Context) error {
 
 		spin.Stop()
 
+		// Directories are created as files are uploaded, so
+		// their permissions are set once all are there.
+		for _, dir := range uploadedDirs(files, cfg.Source, cfg.Map) {
+			if err := applyMode(conn, atomicpath+dir.name, dirMode(cfg, dir)); err != nil {
+				color.Yellow("! Permissions of %s couldn't be changed", dir.name)
+			}
+		}
+
 		// Postdeploy commands.
 		if len(cfg.Postdeploy) > 0 {
 			fmt.Println()
@@ -146,7 +219,7 @@ func Deploy(ctx *cli.Context) error {
 				spin.Start()
 
 				log := logger.New(conn)
-				_, err = log.Write(len(files), cfg.Branch, commit, message)
+				_, err = log.Write(len(files), cfg.Branch, head, message)
 				spin.Stop()
 
 				if err != nil {
@@ -160,7 +233,13 @@ func Deploy(ctx *cli.Context) error {
 				// Create the symlink to the /current directory.
 				spin.Prefix = fmt.Sprintf("Creating symlink to '%s'", cfg.Currdir)
 				spin.Start()
-				_, err = conn.Exec(fmt.Sprintf("ln -sfn %s %s", atomicpath, cfg.Currdir))
+				err = conn.Symlink(strings.TrimRight(atomicpath, "/"), cfg.Currdir)
+
+				// Protocols without symlinks may still run
+				// commands on the server.
+				if server.IsUnsupported(err) {
+					_, err = conn.Exec(fmt.Sprintf("ln -sfn %s %s", atomicpath, cfg.Currdir))
+				}
 				spin.Stop()
 				if err != nil {
 					color.Red("Symlink creation failed with: %s", err.Error())
@@ -172,11 +251,20 @@ func Deploy(ctx *cli.Context) error {
 				spin.Start()
 
 				remoteCfg := config.NewRemote(conn)
-				err := remoteCfg.Write(commit)
+				err := remoteCfg.Write(head)
+
+				// Without includes, there's no manifest to keep.
+				var manifesterr error
+				if len(includes) > 0 || len(deployed) > 0 {
+					manifesterr = writeIncludes(conn, includes, deployed, failed)
+				}
+
 				spin.Stop()
 
 				if err != nil {
 					color.Red("\nProject deployed, but remote revision couldn't be written. Try running 'steer sync'.")
+				} else if manifesterr != nil {
+					color.Red("\nProject deployed, but the includes manifest couldn't be written. Includes will be uploaded again next time.")
 				} else {
 					color.Yellow("\nProject deployed successfully.")
 				}
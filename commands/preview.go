@@ -34,33 +34,34 @@ func Preview(ctx *cli.Context) error {
 			fmt.Println()
 		}
 
-		vcs, err := git.New(cfg.Branch)
+		vcs, err := git.New(cfg.Branch, cfg.Gitbackend)
 		if err != nil {
 			color.Red(err.Error())
 			return
 		}
 
-		files := vcs.Changes(rev, commit)
-		files = addIncludes(files, cfg.Include)
-		files = removeExcludes(files, cfg.Exclude)
-
-		for _, file := range files {
-			switch file.Operation {
-			case git.ADDED, git.COPIED:
-				color.Set(color.FgGreen)
-			case git.MODIFIED, git.RENAMED, git.TYPE:
-				color.Set(color.FgBlue)
-			case git.DELETED:
-				color.Set(color.FgRed)
-			case git.UNKNOWN:
-				color.Set(color.FgBlack)
-			default:
-				color.Set(color.FgWhite)
-			}
+		vcs.ExcludeModules(cfg.Exmodules)
 
-			fmt.Printf("[%s] %s\n", strings.ToUpper(file.Operation[0:3]), file.Name)
+		head := commit
+		if head == "" {
+			head = vcs.RefHead()
+		}
 
-			color.Unset()
+		files, err := listChanges(vcs, rev, head)
+		if err != nil {
+			color.Red(err.Error())
+			return
+		}
+
+		files, _ = prepareFiles(files, cfg, vcs, head, readIncludes(conn))
+		if !checkLfs(vcs, head, files) {
+			fmt.Println()
+		}
+
+		if len(cfg.Map) == 0 {
+			printFiles(files)
+		} else {
+			files = printMapped(files, cfg.Map)
 		}
 
 		if len(files) == 0 {
@@ -70,3 +71,55 @@ func Preview(ctx *cli.Context) error {
 
 	return nil
 }
+
+// Print the files of each mapping on their own, so it's
+// clear where they go. Returns every mapped file.
+func printMapped(files []git.File, mappings []config.Mapping) []git.File {
+	mapped := []git.File{}
+
+	for i, m := range mappings {
+		group := mapFiles(files, mappings[i:i+1])
+		if len(group) == 0 {
+			continue
+		}
+
+		local := m.Local
+		if local == "" {
+			local = "."
+		}
+
+		color.Yellow("%s => %s", local, m.Remote)
+		printFiles(group)
+		fmt.Println()
+
+		mapped = append(mapped, group...)
+	}
+
+	return mapped
+}
+
+// Print files, colored by operation.
+func printFiles(files []git.File) {
+	for _, file := range files {
+		switch file.Operation {
+		case git.ADDED, git.COPIED:
+			color.Set(color.FgGreen)
+		case git.MODIFIED, git.RENAMED, git.TYPE:
+			color.Set(color.FgBlue)
+		case git.DELETED:
+			color.Set(color.FgRed)
+		case git.UNKNOWN:
+			color.Set(color.FgBlack)
+		default:
+			color.Set(color.FgWhite)
+		}
+
+		if file.Operation == git.RENAMED {
+			fmt.Printf("[%s] %s -> %s\n", strings.ToUpper(file.Operation[0:3]), file.OldName, file.Name)
+		} else {
+			fmt.Printf("[%s] %s\n", strings.ToUpper(file.Operation[0:3]), file.Name)
+		}
+
+		color.Unset()
+	}
+}
@@ -32,19 +32,27 @@ func Status(ctx *cli.Context) error {
 			fmt.Println()
 		}
 
-		vcs, err := git.New(cfg.Branch)
+		vcs, err := git.New(cfg.Branch, cfg.Gitbackend)
 		if err != nil {
 			color.Red(err.Error())
 			return
 		}
 
-		files := vcs.Changes(rev, "")
-		files = addIncludes(files, cfg.Include)
-		files = removeExcludes(files, cfg.Exclude)
+		vcs.ExcludeModules(cfg.Exmodules)
 
 		color.White("Remote commit: %s", rev)
-		color.White("Local HEAD: %s", vcs.RefHead())
-		color.Green("%d file(s) changed since last commit", len(files))
+		color.White("Local %s: %s", cfg.Branch, vcs.RefHead())
+
+		files, err := vcs.Changes(rev, "")
+		if git.IsUnknownRevision(err) {
+			color.Red("Remote commit isn't in the local history. Deploying will try to fetch it.")
+		} else if err != nil {
+			color.Red(err.Error())
+		} else {
+			files, _ = prepareFiles(files, cfg, vcs, vcs.RefHead(), readIncludes(conn))
+			files = mapFiles(files, cfg.Map)
+			color.Green("%d file(s) changed since last commit", len(files))
+		}
 
 		if deployInProgress(conn) {
 			color.Red("A deployment is already in progress.")
@@ -18,27 +18,24 @@ func Sync(ctx *cli.Context) error {
 	spin := spinner.New(spinner.CharSets[21], 100*time.Millisecond)
 
 	eachServer(bootstrap(all, servers), func(cfg config.SectionConfig, conn *server.Connection) {
-		// Read the head commit if a specific commit isn't set.
-		if commit == "" {
-			vcs, err := git.New(cfg.Branch)
+		// Read the branch's latest commit if a specific commit
+		// isn't set. Servers may be on different branches.
+		head := commit
+		if head == "" {
+			vcs, err := git.New(cfg.Branch, cfg.Gitbackend)
 			if err != nil {
 				color.Red(err.Error())
 				return
 			}
 
-			head := vcs.RefHead()
-			if head == "" {
-				color.Red("Couldn't get the latest commit hash from the git repo.")
-				return
-			}
-			commit = head
+			head = vcs.RefHead()
 		}
 
 		spin.Prefix = "Writing remote revision file "
 		spin.Start()
 
 		remoteCfg := config.NewRemote(conn)
-		err := remoteCfg.Write(commit)
+		err := remoteCfg.Write(head)
 		spin.Stop()
 
 		if err != nil {
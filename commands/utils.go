@@ -14,11 +14,24 @@ import (
 // Show a nice badge with some useful info.
 func showBadge(cfg config.SectionConfig) {
 	color.Yellow("+ ---------------------------------+")
-	color.Yellow("+ Server %s [%s]", cfg.Host, cfg.Section)
+	color.Yellow("+ Server %s [%s]", serverName(cfg), cfg.Section)
 	color.Yellow("+ Branch [%s]", cfg.Branch)
 	color.Yellow("+ --------------------------------- +\n\n")
 }
 
+// Name of the server for display. Local directories and
+// buckets don't have a host, so their path is shown.
+func serverName(cfg config.SectionConfig) string {
+	switch cfg.Scheme {
+	case "file":
+		return cfg.Path
+	case "s3":
+		return "s3://" + cfg.Bucket + "/" + strings.TrimLeft(cfg.Path, "/")
+	}
+
+	return cfg.Host
+}
+
 // Ask for y/n confirmation.
 func askForConfirmation(message string) bool {
 	reader := bufio.NewReader(os.Stdin)
@@ -43,7 +56,7 @@ func askForUsername(message string) string {
 
 // Ask for password interactively.
 func askForPassword(message string) string {
-	color.New(color.FgWhite).Printf(message)
+	color.New(color.FgWhite).Print(message)
 	password, _ := terminal.ReadPassword(int(syscall.Stdin))
 
 	return strings.TrimSpace(string(password))
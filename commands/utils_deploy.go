@@ -3,10 +3,19 @@ package commands
 import (
 	"fmt"
 	"time"
+	"errors"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	remotepath "path"
+	"sort"
 	"github.com/fatih/color"
 	"github.com/briandowns/spinner"
 	"github.com/fadion/steer/server"
-	"os"
+	"github.com/fadion/steer/config"
+	"github.com/fadion/steer/git"
+	"github.com/fadion/steer/rules"
+	"strings"
 )
 
 var progressindicator = ".steer-process"
@@ -30,15 +39,372 @@ func executeCommands(commands []string, conn *server.Connection) {
 }
 
 func createProgressIndicator(conn *server.Connection) {
-	f, err := os.Create(progressindicator)
+	conn.Put(strings.NewReader(""), progressindicator)
+}
+
+// Check that the Git LFS objects of the files are in the
+// local store, listing the ones that aren't.
+func checkLfs(vcs *git.Version, commit string, files []git.File) bool {
+	missing, err := vcs.MissingLfs(commit, files)
 	if err != nil {
-		return
+		color.Red(err.Error())
+		return false
 	}
 
-	defer f.Close()
-	defer os.Remove(progressindicator)
+	if len(missing) == 0 {
+		return true
+	}
 
-	conn.Upload(progressindicator, progressindicator)
+	color.Red("These files are stored in Git LFS, but their objects aren't in the local store:")
+	for _, name := range missing {
+		color.Red("  %s", name)
+	}
+
+	color.Red("Run 'git lfs fetch' to download them.")
+
+	return false
+}
+
+// Returned when a symlink can't be created on the server
+// and the fallback is to skip it.
+var errSymlinkSkipped = errors.New("Symlink skipped.")
+
+// Upload a file as it is in the commit. Includes aren't
+// tracked, so they're read from the working tree.
+func uploadFile(conn *server.Connection, vcs *git.Version, cfg config.SectionConfig, commit string, file git.File, destination string) error {
+	if file.IsSymlink() {
+		return uploadSymlink(conn, vcs, cfg, commit, file, destination)
+	}
+
+	// What was there before, like a symlink, is removed so
+	// it isn't written through.
+	if file.Operation == git.TYPE {
+		conn.Delete(destination)
+	}
+
+	if file.Worktree {
+		return conn.Upload(file.Path(), destination)
+	}
+
+	blob, err := vcs.Blob(commit, file.Path())
+	if err != nil {
+		return err
+	}
+
+	defer blob.Close()
+
+	return conn.Put(blob, destination)
+}
+
+// Create a symlink on the server. Protocols without
+// symlinks either copy what it points to or skip it.
+func uploadSymlink(conn *server.Connection, vcs *git.Version, cfg config.SectionConfig, commit string, file git.File, destination string) error {
+	target, err := readLink(vcs, commit, file)
+	if err != nil {
+		return err
+	}
+
+	if file.Operation == git.TYPE {
+		conn.Delete(destination)
+	}
+
+	err = conn.Symlink(target, destination)
+	if !server.IsUnsupported(err) {
+		return err
+	}
+
+	if cfg.Symlinks == "skip" {
+		return errSymlinkSkipped
+	}
+
+	return copyLink(conn, vcs, commit, file, destination)
+}
+
+// Target of a symlink. Git stores it as the contents.
+func readLink(vcs *git.Version, commit string, file git.File) (string, error) {
+	if file.Worktree {
+		return os.Readlink(file.Path())
+	}
+
+	blob, err := vcs.Blob(commit, file.Path())
+	if err != nil {
+		return "", err
+	}
+
+	defer blob.Close()
+
+	target, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return "", err
+	}
+
+	return string(target), nil
+}
+
+// Upload what a symlink points to, as it is in the commit.
+// Directories are uploaded with all of their files. Targets
+// outside the repository aren't deployed.
+func copyLink(conn *server.Connection, vcs *git.Version, commit string, file git.File, destination string) error {
+	if file.Worktree {
+		return copyWorktreeLink(conn, file.Path(), destination)
+	}
+
+	target, err := vcs.ResolveLink(commit, file.Path())
+	if err != nil {
+		return err
+	}
+
+	files, err := vcs.Files(commit, target)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		source := f.Name
+
+		// Links inside a directory are copied too, as long
+		// as they point to files.
+		if f.IsSymlink() {
+			if source, err = vcs.ResolveLink(commit, f.Name); err != nil {
+				return err
+			}
+
+			if resolved, err := vcs.Files(commit, source); err != nil || len(resolved) != 1 || resolved[0].Name != source {
+				return fmt.Errorf("%s points to a directory inside %s, which can't be copied.", f.Name, target)
+			}
+		}
+
+		blob, err := vcs.Blob(commit, source)
+		if err != nil {
+			return err
+		}
+
+		err = conn.Put(blob, destination+f.Name[len(target):])
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upload what a symlink of the working tree points to.
+func copyWorktreeLink(conn *server.Connection, path, destination string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("%s points to a file that doesn't exist in the working tree.", path)
+	}
+
+	root, _ := filepath.EvalSymlinks(".")
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s points outside the repository.", path)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return conn.Upload(resolved, destination)
+	}
+
+	return filepath.Walk(resolved, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name, _ := filepath.Rel(resolved, file)
+
+		return conn.Upload(file, destination+"/"+filepath.ToSlash(name))
+	})
+}
+
+// Rename a file on the server when its contents didn't
+// change. Otherwise, or when the server can't rename it, the
+// new file is uploaded and the old one deleted.
+func renameFile(conn *server.Connection, vcs *git.Version, cfg config.SectionConfig, commit string, file git.File, basepath string) error {
+	if file.Similarity == 100 {
+		if err := conn.Rename(basepath+file.OldName, basepath+file.Name); err == nil {
+			return nil
+		}
+	}
+
+	if err := uploadFile(conn, vcs, cfg, commit, file, basepath+file.Name); err != nil {
+		return err
+	}
+
+	// The old file may not be on the server, which is
+	// what's wanted anyway.
+	conn.Delete(basepath + file.OldName)
+
+	return nil
+}
+
+// Mode of an uploaded file. A matching rule wins over the
+// executable bit from git, which is added to the default
+// mode. Zero leaves what the server applies.
+func fileMode(cfg config.SectionConfig, file git.File) os.FileMode {
+	if mode, ok := matchPermission(cfg.Perms, sourceName(file, cfg.Source), false); ok {
+		return mode
+	}
+
+	if file.Mode == "100755" {
+		if cfg.Filemode == 0 {
+			return 0755
+		}
+
+		// Executable for whoever can read it.
+		return cfg.Filemode | (cfg.Filemode&0444)>>2
+	}
+
+	return cfg.Filemode
+}
+
+// Mode of a directory, from rules or the default.
+func dirMode(cfg config.SectionConfig, dir remoteDir) os.FileMode {
+	if mode, ok := matchPermission(cfg.Perms, dir.local, true); ok {
+		return mode
+	}
+
+	return cfg.Dirmode
+}
+
+// Name of a file relative to the source directory. Rules
+// match it rather than the name on the server, which
+// mappings change.
+func sourceName(file git.File, source string) string {
+	if name, inside := relativeToSource(file.Path(), source); inside {
+		return name
+	}
+
+	return file.Name
+}
+
+// First rule that matches a path. Only patterns ending with
+// a slash apply to directories.
+func matchPermission(perms []config.Permission, name string, dir bool) (os.FileMode, bool) {
+	for _, perm := range perms {
+		if strings.HasSuffix(perm.Pattern, "/") == dir && rules.Match(strings.TrimSuffix(perm.Pattern, "/"), name) {
+			return perm.Mode, true
+		}
+	}
+
+	return 0, false
+}
+
+// Change permissions, unless there's nothing to change or
+// the protocol has no permissions.
+func applyMode(conn *server.Connection, destination string, mode os.FileMode) error {
+	if mode == 0 {
+		return nil
+	}
+
+	if err := conn.Chmod(destination, mode); err != nil && !server.IsUnsupported(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Set the permissions of an uploaded file. Symlinks keep
+// those of what they point to.
+func setFileMode(conn *server.Connection, cfg config.SectionConfig, file git.File, basepath string) error {
+	if file.IsSymlink() {
+		return nil
+	}
+
+	return applyMode(conn, basepath+file.Name, fileMode(cfg, file))
+}
+
+// A directory on the server, with where it is in the source
+// directory.
+type remoteDir struct {
+	name  string
+	local string
+}
+
+// Directories of the uploaded files, parents included, so
+// their permissions can be set. The root and the remote
+// directories of mappings are left as they are.
+func uploadedDirs(files []git.File, source string, mappings []config.Mapping) []remoteDir {
+	seen := map[string]bool{".": true, "/": true}
+	for _, m := range mappings {
+		seen[m.Remote] = true
+	}
+
+	var dirs []remoteDir
+
+	for _, file := range files {
+		if file.Operation == git.DELETED || file.IsSymlink() {
+			continue
+		}
+
+		// Mappings only change the start of a name, so both
+		// are walked up together.
+		dir, local := remotepath.Dir(file.Name), remotepath.Dir(sourceName(file, source))
+		for !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, remoteDir{name: dir, local: local})
+			dir, local = remotepath.Dir(dir), remotepath.Dir(local)
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].name < dirs[j].name
+	})
+
+	return dirs
+}
+
+// Split renames from the other changes. A rename whose
+// source is where another one goes is uploaded instead, as
+// its source is replaced. Swaps and chains can't race then,
+// and the renames that are left don't depend on each other.
+func orderRenames(files []git.File) ([]git.File, []git.File) {
+	destinations := map[string]bool{}
+	for _, file := range files {
+		if file.Operation == git.RENAMED {
+			destinations[file.Name] = true
+		}
+	}
+
+	var renames, others []git.File
+	for _, file := range files {
+		switch {
+		case file.Operation != git.RENAMED:
+			others = append(others, file)
+		case destinations[file.OldName]:
+			file.Operation, file.OldName, file.Similarity = git.ADDED, "", 0
+			others = append(others, file)
+		default:
+			renames = append(renames, file)
+		}
+	}
+
+	return renames, others
+}
+
+// Hashes of the includes as last deployed. A missing
+// manifest means none were.
+func readIncludes(conn *server.Connection) map[string]string {
+	hashes, _ := config.NewManifest(conn).Read()
+	return hashes
+}
+
+// Write the hashes of the deployed includes. Those that
+// failed keep the hash they had, so they're retried.
+func writeIncludes(conn *server.Connection, hashes, deployed map[string]string, failed map[string]bool) error {
+	for path := range failed {
+		if previous, ok := deployed[path]; ok {
+			hashes[path] = previous
+		} else {
+			delete(hashes, path)
+		}
+	}
+
+	return config.NewManifest(conn).Write(hashes)
 }
 
 func deleteProgressIndicator(conn *server.Connection) {
@@ -52,4 +418,36 @@ func deployInProgress(conn *server.Connection) bool {
 	}
 
 	return true
-}
\ No newline at end of file
+}
+
+// List the changes since the remote revision. When it isn't
+// in the local history, it's fetched from origin. Failing
+// that, every file can be deployed instead, as if it was a
+// fresh deploy. Without the old revision, there's no telling
+// which files were removed, so nothing is deleted.
+func listChanges(vcs *git.Version, rev, head string) ([]git.File, error) {
+	files, err := vcs.Changes(rev, head)
+	if !git.IsUnknownRevision(err) {
+		return files, err
+	}
+
+	spin := spinner.New(spinner.CharSets[21], 100*time.Millisecond)
+	spin.Prefix = fmt.Sprintf("Remote revision %s isn't in the local history. Fetching it ", rev)
+	spin.Start()
+	err = vcs.Fetch(rev)
+	spin.Stop()
+
+	if err == nil {
+		return vcs.Changes(rev, head)
+	}
+
+	color.Red("Remote revision %s couldn't be found, even after fetching. %s", rev, err.Error())
+
+	color.Yellow("Deploying the whole tree uploads every file, but files removed since %s won't be deleted from the server.", rev)
+
+	if !askForConfirmation("Deploy every file and skip deletions?") {
+		return nil, fmt.Errorf("Changes couldn't be listed. Fetch the missing history or run a fresh deploy.")
+	}
+
+	return vcs.Changes("", head)
+}
@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"testing"
+	"os"
+	"reflect"
+	"github.com/fadion/steer/config"
+	"github.com/fadion/steer/git"
+)
+
+func TestOrderRenames(t *testing.T) {
+	files := []git.File{
+		{Name: "b", OldName: "a", Operation: git.RENAMED, Similarity: 100},
+		{Name: "c", OldName: "b", Operation: git.RENAMED, Similarity: 100},
+		{Name: "y", OldName: "x", Operation: git.RENAMED, Similarity: 100},
+		{Name: "x", OldName: "y", Operation: git.RENAMED, Similarity: 100},
+		{Name: "moved", OldName: "old", Operation: git.RENAMED, Similarity: 80},
+		{Name: "new", Operation: git.ADDED},
+	}
+
+	renames, others := orderRenames(files)
+
+	expected := []git.File{files[0], files[4]}
+	if !reflect.DeepEqual(renames, expected) {
+		t.Fatalf("Expected renames %+v but got %+v", expected, renames)
+	}
+
+	// Chained and swapped renames are uploaded.
+	expected = []git.File{
+		{Name: "c", Operation: git.ADDED},
+		{Name: "y", Operation: git.ADDED},
+		{Name: "x", Operation: git.ADDED},
+		{Name: "new", Operation: git.ADDED},
+	}
+
+	if !reflect.DeepEqual(others, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, others)
+	}
+}
+
+func TestMatchPermission(t *testing.T) {
+	perms := []config.Permission{
+		{Pattern: "*.sh", Mode: 0750},
+		{Pattern: "config/local.php", Mode: 0600},
+		{Pattern: "storage/", Mode: 0775},
+	}
+
+	cases := []struct {
+		name string
+		dir  bool
+		mode os.FileMode
+		ok   bool
+	}{
+		{"bin/run.sh", false, 0750, true},
+		{"config/local.php", false, 0600, true},
+		{"app/config/local.php", false, 0, false},
+		{"storage", true, 0775, true},
+		{"app/storage", true, 0775, true},
+		{"storage", false, 0, false},
+		{"index.php", false, 0, false},
+	}
+
+	for _, c := range cases {
+		mode, ok := matchPermission(perms, c.name, c.dir)
+		if mode != c.mode || ok != c.ok {
+			t.Errorf("Expected %s to be %o (%v) but got %o (%v)", c.name, c.mode, c.ok, mode, ok)
+		}
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	cfg := config.SectionConfig{
+		Source: "public",
+		Perms:  []config.Permission{{Pattern: "config/local.php", Mode: 0600}},
+	}
+
+	// Rules match where files are in the source, even when
+	// they're mapped elsewhere.
+	file := git.File{Name: "/etc/app/local.php", Source: "public/config/local.php", Mode: "100644"}
+	if mode := fileMode(cfg, file); mode != 0600 {
+		t.Fatalf("Expected a mode of 600, but got %o", mode)
+	}
+
+	file = git.File{Name: "run.sh", Source: "public/run.sh", Mode: "100755"}
+	if mode := fileMode(cfg, file); mode != 0755 {
+		t.Fatalf("Expected a mode of 755, but got %o", mode)
+	}
+
+	cfg.Filemode = 0640
+	if mode := fileMode(cfg, file); mode != 0750 {
+		t.Fatalf("Expected a mode of 750, but got %o", mode)
+	}
+
+	file = git.File{Name: "index.php", Source: "public/index.php", Mode: "100644"}
+	if mode := fileMode(cfg, file); mode != 0640 {
+		t.Fatalf("Expected a mode of 640, but got %o", mode)
+	}
+}
+
+func TestUploadedDirs(t *testing.T) {
+	files := []git.File{
+		{Name: "/var/www/app/views/home.php", Source: "app/views/home.php", Operation: git.ADDED},
+		{Name: "/var/www/app/index.php", Source: "app/index.php", Operation: git.MODIFIED},
+		{Name: "/var/www/old/gone.php", Operation: git.DELETED},
+		{Name: "lib/util.php", Operation: git.ADDED},
+	}
+
+	mappings := []config.Mapping{{Local: "", Remote: "/var/www"}}
+
+	actual := uploadedDirs(files, "", mappings)
+	expected := []remoteDir{
+		{name: "/var/www/app", local: "app"},
+		{name: "/var/www/app/views", local: "app/views"},
+		{name: "lib", local: "lib"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+}
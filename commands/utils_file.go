@@ -3,43 +3,69 @@ package commands
 import (
 	"fmt"
 	"os"
+	"io"
+	"sort"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
+	remotepath "path"
 	"github.com/fadion/steer/git"
+	"github.com/fadion/steer/config"
 )
 
-// Add includes to the list of files.
-func addIncludes(current []git.File, files []string) []git.File {
-	if len(files) == 0 {
+// Keep the files in the source directory, named relative
+// to it, as that's what ends up on the server.
+func filterSource(current []git.File, source string) []git.File {
+	if source == "" {
 		return current
 	}
 
-	for _, file := range expandFiles(files) {
-		current = append(current, git.File{
-			Name:      file,
-			Operation: git.ADDED,
-		})
+	output := []git.File{}
+	for _, c := range current {
+		name, inside := relativeToSource(c.Name, source)
+		oldname, oldinside := relativeToSource(c.OldName, source)
+
+		switch {
+		case inside && c.Operation == git.RENAMED && !oldinside:
+			// Moved in from outside, so it's new on the server.
+			output = append(output, git.File{Name: name, Operation: git.ADDED, Source: c.Path(), Mode: c.Mode})
+		case inside:
+			c.Source, c.Name = c.Path(), name
+			if c.Operation == git.RENAMED {
+				c.OldName = oldname
+			}
+
+			output = append(output, c)
+		case c.Operation == git.RENAMED && oldinside:
+			// Moved out, so it's gone from the server.
+			output = append(output, git.File{Name: oldname, Operation: git.DELETED})
+		}
 	}
 
-	return current
+	return output
 }
 
-// Remove excludes from the list of files.
-func removeExcludes(current []git.File, files []string) []git.File {
-	// Don't deploy steer configuration file.
-	files = append(files, ".steer")
-	output := []git.File{}
+// Split the files between mappings, named after where they
+// go on the server. Those outside of every mapping are left
+// out. Without mappings, files go to the root.
+func mapFiles(current []git.File, mappings []config.Mapping) []git.File {
+	if len(mappings) == 0 {
+		return current
+	}
 
-	for _, c := range current {
-		remove := false
-		for _, f := range expandFiles(files) {
-			if strings.Trim(f, "/") == strings.Trim(c.Name, "/") {
-				remove = true
-				break
+	output := []git.File{}
+	for _, m := range mappings {
+		for _, c := range filterSource(current, m.Local) {
+			// Files are read from the repository by their path,
+			// which the name no longer is.
+			c.Source = c.Path()
+			c.Name = remotepath.Join(m.Remote, c.Name)
+			if c.OldName != "" {
+				c.OldName = remotepath.Join(m.Remote, c.OldName)
 			}
-		}
 
-		if !remove {
 			output = append(output, c)
 		}
 	}
@@ -47,31 +73,262 @@ func removeExcludes(current []git.File, files []string) []git.File {
 	return output
 }
 
-// Read files and directories.
-func expandFiles(files []string) []string {
-	var output []string
+// Name of a file relative to the source directory, and
+// whether it's inside it.
+func relativeToSource(name, source string) (string, bool) {
+	if source == "" {
+		return name, true
+	}
+
+	if !strings.HasPrefix(name, source+"/") {
+		return "", false
+	}
+
+	return name[len(source)+1:], true
+}
+
+// Prepare the files to deploy. Ignored files are removed,
+// the rest scoped to the source directory, and then includes
+// and excludes are applied. Includes are compared to the
+// hashes they were last deployed with, which are returned
+// updated.
+func prepareFiles(files []git.File, cfg config.SectionConfig, vcs *git.Version, commit string, deployed map[string]string) ([]git.File, map[string]string) {
+	tracked := trackedFiles(vcs, commit, cfg.Include, deployed)
+
+	files = removeMatching(files, readIgnores(vcs, commit))
+	files = filterSource(files, cfg.Source)
+	files = addIncludes(files, cfg.Include, cfg.Source, tracked)
+	files = removeExcludes(files, cfg.Exclude)
 
-	for _, k := range files {
-		file, err := os.Stat(k)
+	return diffIncludes(files, deployed, cfg.Source, tracked)
+}
+
+// Files tracked in the commit, by path in the repository.
+// Only includes need them, so the tree isn't listed
+// otherwise.
+func trackedFiles(vcs *git.Version, commit string, includes []string, deployed map[string]string) map[string]bool {
+	tracked := map[string]bool{}
+	if len(includes) == 0 && len(deployed) == 0 {
+		return tracked
+	}
+
+	files, err := vcs.Changes("", commit)
+	if err != nil {
+		return tracked
+	}
+
+	for _, file := range files {
+		tracked[file.Name] = true
+	}
+
+	return tracked
+}
+
+// Files that are never deployed: those in .steerignore and
+// those marked export-ignore in .gitattributes, just as git
+// archive leaves them out. Patterns are relative to the
+// repository.
+func readIgnores(vcs *git.Version, commit string) *git.Matcher {
+	// Don't deploy steer's own files.
+	lines := []string{"/.steer", "/.steerignore"}
+
+	if contents, err := ioutil.ReadFile(".steerignore"); err == nil {
+		lines = append(lines, strings.Split(string(contents), "\n")...)
+	}
+
+	if blob, err := vcs.Blob(commit, ".gitattributes"); err == nil {
+		contents, err := ioutil.ReadAll(blob)
+		blob.Close()
+
+		if err == nil {
+			lines = append(lines, git.ParseAttributes(string(contents), "export-ignore")...)
+		}
+	}
+
+	return git.NewMatcher(lines)
+}
+
+// Add untracked files from the working tree that match the
+// includes. They're relative to the source directory and
+// directories are read recursively.
+func addIncludes(current []git.File, patterns []string, source string, tracked map[string]bool) []git.File {
+	if len(patterns) == 0 {
+		return current
+	}
+
+	matcher := git.NewMatcher(patterns)
+	existing := map[string]bool{}
+	for _, c := range current {
+		existing[c.Name] = true
+	}
+
+	root := source
+	if root == "" {
+		root = "."
+	}
+
+	filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
 		if err != nil {
 			fmt.Println(err.Error())
+			return nil
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		name, _ := filepath.Rel(root, file)
+		name = filepath.ToSlash(name)
+
+		// Tracked files are deployed as they're committed, not
+		// with what's uncommitted in the working tree.
+		if tracked[filepath.ToSlash(file)] || existing[name] || !matcher.Match(name) {
+			return nil
+		}
+
+		include := git.File{
+			Name:      name,
+			Operation: git.ADDED,
+			Worktree:  true,
+			Source:    filepath.ToSlash(file),
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			include.Mode = "120000"
+		} else if info.Mode()&0111 != 0 {
+			include.Mode = "100755"
+		}
+
+		current = append(current, include)
+
+		return nil
+	})
+
+	return current
+}
+
+// Keep the includes that changed since they were deployed,
+// and delete those that aren't included anymore. Hashes are
+// keyed by the path in the working tree. Files that are now
+// tracked stay, as they're deployed from the commit.
+func diffIncludes(current []git.File, deployed map[string]string, source string, tracked map[string]bool) ([]git.File, map[string]string) {
+	output := []git.File{}
+	hashes := map[string]string{}
+
+	for _, c := range current {
+		if !c.Worktree {
+			output = append(output, c)
+			continue
+		}
+
+		hash, err := hashInclude(c)
+		if err != nil {
+			output = append(output, c)
 			continue
 		}
 
-		if file.IsDir() {
-			dirfiles, err := ioutil.ReadDir(k)
-			if err != nil {
+		hashes[c.Path()] = hash
+
+		if previous, ok := deployed[c.Path()]; ok {
+			if previous == hash {
 				continue
 			}
 
-			for _, dirfile := range dirfiles {
-				if !dirfile.IsDir() {
-					output = append(output, fmt.Sprintf("%s%s%s", k, string(os.PathSeparator), dirfile.Name()))
-				}
+			c.Operation = git.MODIFIED
+		}
+
+		output = append(output, c)
+	}
+
+	// Paths are sorted, so deletions are in a stable order.
+	var gone []string
+	for path := range deployed {
+		if _, ok := hashes[path]; !ok && !tracked[path] {
+			gone = append(gone, path)
+		}
+	}
+
+	sort.Strings(gone)
+
+	for _, path := range gone {
+		if name, inside := relativeToSource(path, source); inside {
+			output = append(output, git.File{
+				Name:      name,
+				Operation: git.DELETED,
+				Worktree:  true,
+				Source:    path,
+			})
+		}
+	}
+
+	return output, hashes
+}
+
+// Hash the contents of an include. Symlinks are hashed by
+// what they point to, as git does.
+func hashInclude(file git.File) (string, error) {
+	h := sha256.New()
+
+	if file.IsSymlink() {
+		target, err := os.Readlink(file.Path())
+		if err != nil {
+			return "", err
+		}
+
+		io.WriteString(h, "link:"+target)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	f, err := os.Open(file.Path())
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Remove the files that match the excludes. They're
+// relative to the source directory.
+func removeExcludes(current []git.File, patterns []string) []git.File {
+	if len(patterns) == 0 {
+		return current
+	}
+
+	return removeMatching(current, git.NewMatcher(patterns))
+}
+
+// Remove the files that match.
+func removeMatching(current []git.File, matcher *git.Matcher) []git.File {
+	output := []git.File{}
+
+	for _, c := range current {
+		if matcher.Match(c.Name) {
+			// A file renamed to an excluded name leaves the
+			// old one to be deleted.
+			if c.Operation == git.RENAMED && !matcher.Match(c.OldName) {
+				output = append(output, git.File{Name: c.OldName, Operation: git.DELETED})
 			}
-		} else {
-			output = append(output, k)
+
+			continue
 		}
+
+		// One renamed from an excluded name was never on the
+		// server, so it's a new file.
+		if c.Operation == git.RENAMED && matcher.Match(c.OldName) {
+			c.Operation, c.OldName, c.Similarity = git.ADDED, "", 0
+		}
+
+		output = append(output, c)
 	}
 
 	return output
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"github.com/fadion/steer/config"
+	"github.com/fadion/steer/git"
+	"github.com/fadion/steer/server"
+)
+
+func TestMapFiles(t *testing.T) {
+	files := []git.File{
+		{Name: "index.php", Operation: git.MODIFIED},
+		{Name: "app/main.php", Operation: git.ADDED},
+	}
+
+	mappings := []config.Mapping{
+		{Local: "", Remote: "/var/www"},
+		{Local: "app", Remote: "/srv/app"},
+	}
+
+	actual := mapFiles(files, mappings)
+	expected := []git.File{
+		{Name: "/var/www/index.php", Operation: git.MODIFIED, Source: "index.php"},
+		{Name: "/var/www/app/main.php", Operation: git.ADDED, Source: "app/main.php"},
+		{Name: "/srv/app/main.php", Operation: git.ADDED, Source: "app/main.php"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+
+	// Files are still read from where they are in the
+	// repository.
+	if actual[0].Path() != "index.php" {
+		t.Fatalf("Expected the path of index.php, but got %s", actual[0].Path())
+	}
+}
+
+func TestFilterSourceMovedIn(t *testing.T) {
+	files := []git.File{
+		{Name: "public/link", OldName: "link", Operation: git.RENAMED, Similarity: 100, Mode: "120000"},
+		{Name: "public/run.sh", OldName: "bin/run.sh", Operation: git.RENAMED, Similarity: 100, Mode: "100755"},
+	}
+
+	actual := filterSource(files, "public")
+	expected := []git.File{
+		{Name: "link", Operation: git.ADDED, Source: "public/link", Mode: "120000"},
+		{Name: "run.sh", Operation: git.ADDED, Source: "public/run.sh", Mode: "100755"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+}
+
+// Work in a temporary directory with the given files.
+func workingTree(t *testing.T, files map[string]string) func() {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("%s couldn't be written.", name)
+		}
+	}
+
+	os.Chdir(dir)
+
+	return func() {
+		os.Chdir(cwd)
+	}
+}
+
+func TestAddIncludes(t *testing.T) {
+	defer workingTree(t, map[string]string{
+		"public/build/app.js":  "built",
+		"public/build/app.css": "edited, but tracked",
+		"public/index.php":     "tracked",
+		"vendor/lib.php":       "outside the source",
+	})()
+
+	tracked := map[string]bool{"public/build/app.css": true, "public/index.php": true}
+
+	actual := addIncludes(nil, []string{"build/", "index.php"}, "public", tracked)
+	expected := []git.File{
+		{Name: "build/app.js", Operation: git.ADDED, Worktree: true, Source: "public/build/app.js"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+}
+
+func TestDiffIncludes(t *testing.T) {
+	defer workingTree(t, map[string]string{
+		"same.js":    "same",
+		"changed.js": "changed",
+		"new.js":     "new",
+	})()
+
+	current := []git.File{
+		{Name: "index.php", Operation: git.MODIFIED},
+		{Name: "same.js", Operation: git.ADDED, Worktree: true, Source: "same.js"},
+		{Name: "changed.js", Operation: git.ADDED, Worktree: true, Source: "changed.js"},
+		{Name: "new.js", Operation: git.ADDED, Worktree: true, Source: "new.js"},
+	}
+
+	hash := func(path string) string {
+		h, _ := hashInclude(git.File{Name: path, Source: path})
+		return h
+	}
+
+	deployed := map[string]string{
+		"same.js":      hash("same.js"),
+		"changed.js":   "old",
+		"removed.js":   "old",
+		"committed.js": "old",
+	}
+
+	files, hashes := diffIncludes(current, deployed, "", map[string]bool{"committed.js": true})
+
+	expected := []git.File{
+		{Name: "index.php", Operation: git.MODIFIED},
+		{Name: "changed.js", Operation: git.MODIFIED, Worktree: true, Source: "changed.js"},
+		{Name: "new.js", Operation: git.ADDED, Worktree: true, Source: "new.js"},
+		{Name: "removed.js", Operation: git.DELETED, Worktree: true, Source: "removed.js"},
+	}
+
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, files)
+	}
+
+	if len(hashes) != 3 || hashes["new.js"] != hash("new.js") {
+		t.Fatalf("Expected the hashes of the current includes, but got %v", hashes)
+	}
+}
+
+func TestIncludesManifest(t *testing.T) {
+	driver, err := server.ConnectFile(server.Params{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Couldn't connect to directory: %s", err.Error())
+	}
+
+	conn := server.Manage(driver)
+
+	if deployed := readIncludes(conn); len(deployed) != 0 {
+		t.Fatalf("Expected no includes before the first deploy, but got %v", deployed)
+	}
+
+	// Failed includes keep the hash they were deployed with,
+	// or are left out, so they're uploaded again.
+	deployed := map[string]string{"a.js": "1", "b.js": "1"}
+	hashes := map[string]string{"a.js": "2", "b.js": "2", "c.js": "2"}
+	failed := map[string]bool{"b.js": true, "c.js": true}
+
+	if err = writeIncludes(conn, hashes, deployed, failed); err != nil {
+		t.Fatalf("Manifest couldn't be written: %s", err.Error())
+	}
+
+	expected := map[string]string{"a.js": "2", "b.js": "1"}
+	if actual := readIncludes(conn); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+}
@@ -3,7 +3,12 @@ package config
 import (
 	"os"
 	"fmt"
+	"strings"
+	"path"
+	"path/filepath"
+	"strconv"
 	"github.com/go-ini/ini"
+	"github.com/fadion/steer/rules"
 )
 
 // Local configuration.
@@ -26,8 +31,33 @@ type SectionConfig struct {
 	Username   string
 	Password   string
 	Privatekey string
+	Passphrase string
+	Cacert     string
+	Clientcert string
+	Clientkey  string
+	Skipverify bool
+	Knownhosts string
+	Hostkey    string
+	Jumphosts  []string
+	Jumpkeys   []string
+	Bucket     string
+	Region     string
+	Endpoint   string
+	Accesskey  string
+	Secretkey  string
+	Mimetypes  bool
+	Cache      []rules.Rule
 	Path       string
+	Source     string
+	Map        []Mapping
 	Branch     string
+	Gitbackend string
+	Exmodules  []string
+	Symlinks   string
+	Filemode   os.FileMode
+	Dirmode    os.FileMode
+	Perms      []Permission
+	Safeupload bool
 	Atomic     bool
 	Reldir     string
 	Currdir    string
@@ -39,17 +69,37 @@ type SectionConfig struct {
 	Postdeploy []string
 }
 
+// Deploys a local directory to a remote one.
+type Mapping struct {
+	Local  string
+	Remote string
+}
+
+// Permissions for the files matching a pattern. Patterns
+// ending with a slash match directories.
+type Permission struct {
+	Pattern string
+	Mode    os.FileMode
+}
+
 // Default configuration.
 type localDefaults struct {
 	scheme     string
 	port       int
 	path       string
 	branch     string
+	gitbackend string
+	symlinks   string
+	safeupload bool
 	atomic     bool
 	reldir     string
 	currdir    string
 	logger     bool
 	maxclients int
+	knownhosts string
+	region     string
+	mimetypes  bool
+	ports      map[string]int
 }
 
 // Initialise a new local config.
@@ -61,11 +111,26 @@ func NewLocal() *LocalConfig {
 			port:       21,
 			path:       "/",
 			branch:     "master",
+			gitbackend: "auto",
+			symlinks:   "copy",
+			safeupload: false,
 			atomic:     false,
 			reldir:     "releases",
 			currdir:    "current",
 			logger:     false,
 			maxclients: 3,
+			knownhosts: "~/.ssh/known_hosts",
+			region:     "us-east-1",
+			mimetypes:  true,
+			// SSH ports are left unset, so they can be resolved
+			// from ~/.ssh/config when connecting.
+			ports: map[string]int{
+				"ftps-implicit": 990,
+				"sftp":          0,
+				"ssh":           0,
+				"webdav":        80,
+				"webdavs":       443,
+			},
 		},
 	}
 }
@@ -130,16 +195,42 @@ func (c *LocalConfig) Read() (*ServerConfig, error) {
 	var out []SectionConfig
 	for _, section := range sections {
 		sec, _ := cfg.GetSection(section)
+		scheme := sec.Key("scheme").In(c.defaults.scheme, []string{"ftp", "ftps", "ftps-implicit", "sftp", "ssh", "file", "s3", "webdav", "webdavs"})
 		out = append(out, SectionConfig{
 			Section:    section,
-			Scheme:     sec.Key("scheme").In(c.defaults.scheme, []string{"ftp", "sftp", "ssh"}),
+			Scheme:     scheme,
 			Host:       sec.Key("host").MustString(""),
-			Port:       sec.Key("port").MustInt(c.defaults.port),
+			Port:       sec.Key("port").MustInt(c.defaultPort(scheme)),
 			Username:   sec.Key("username").MustString(""),
 			Password:   sec.Key("password").MustString(""),
-			Privatekey: sec.Key("privatekey").MustString(""),
+			Privatekey: expandHome(sec.Key("privatekey").MustString("")),
+			Passphrase: sec.Key("passphrase").MustString(""),
+			Cacert:     expandHome(sec.Key("cacert").MustString("")),
+			Clientcert: expandHome(sec.Key("clientcert").MustString("")),
+			Clientkey:  expandHome(sec.Key("clientkey").MustString("")),
+			Skipverify: sec.Key("skipverify").MustBool(false),
+			Knownhosts: expandHome(sec.Key("knownhosts").MustString(c.defaults.knownhosts)),
+			Hostkey:    sec.Key("hostkey").MustString(""),
+			Jumphosts:  sec.Key("jumphost").Strings(","),
+			Jumpkeys:   expandHomes(sec.Key("jumpkey").Strings(",")),
+			Bucket:     sec.Key("bucket").MustString(""),
+			Region:     sec.Key("region").MustString(c.defaults.region),
+			Endpoint:   sec.Key("endpoint").MustString(""),
+			Accesskey:  sec.Key("accesskey").MustString(""),
+			Secretkey:  sec.Key("secretkey").MustString(""),
+			Mimetypes:  sec.Key("contenttype").MustBool(c.defaults.mimetypes),
+			Cache:      parseRules(sec.Key("cachecontrol").String()),
 			Path:       sec.Key("path").MustString(c.defaults.path),
+			Source:     cleanSource(sec.Key("source").MustString("")),
+			Map:        parseMappings(sec.Key("map").String()),
 			Branch:     sec.Key("branch").MustString(c.defaults.branch),
+			Gitbackend: sec.Key("gitbackend").In(c.defaults.gitbackend, []string{"auto", "cli", "native"}),
+			Exmodules:  sec.Key("excludemodules").Strings(","),
+			Symlinks:   sec.Key("symlinkfallback").In(c.defaults.symlinks, []string{"copy", "skip"}),
+			Filemode:   parseMode(sec.Key("filemode").String()),
+			Dirmode:    parseMode(sec.Key("dirmode").String()),
+			Perms:      parsePermissions(sec.Key("permissions").String()),
+			Safeupload: sec.Key("safeupload").MustBool(c.defaults.safeupload),
 			Atomic:     sec.Key("atomic").MustBool(c.defaults.atomic),
 			Reldir:     sec.Key("releasedir").MustString(c.defaults.reldir),
 			Currdir:    sec.Key("currentdir").MustString(c.defaults.currdir),
@@ -154,3 +245,93 @@ func (c *LocalConfig) Read() (*ServerConfig, error) {
 
 	return &ServerConfig{Sections: out}, nil
 }
+
+// Default port for a scheme. Falls back to the generic
+// default when the scheme doesn't have its own.
+func (c *LocalConfig) defaultPort(scheme string) int {
+	if port, ok := c.defaults.ports[scheme]; ok {
+		return port
+	}
+
+	return c.defaults.port
+}
+
+// Clean the source directory, so it's relative to the
+// repository without leading or trailing slashes. The root
+// is an empty string.
+func cleanSource(source string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(source)), "/")
+}
+
+// Parse mappings in the form of "local => remote", separated
+// by commas. Local directories are relative to the source.
+func parseMappings(value string) []Mapping {
+	mappings := []Mapping{}
+
+	for _, part := range strings.Split(value, ",") {
+		pair := strings.SplitN(part, "=>", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		mappings = append(mappings, Mapping{
+			Local:  cleanSource(strings.TrimSpace(pair[0])),
+			Remote: path.Clean("/" + strings.TrimSpace(pair[1])),
+		})
+	}
+
+	return mappings
+}
+
+// Parse rules in the form of "pattern => value", separated by
+// commas. As values may have commas of their own, like in
+// "max-age=600, public", a part without an arrow continues
+// the previous value.
+func parseRules(value string) []rules.Rule {
+	parsed := []rules.Rule{}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		pair := strings.SplitN(part, "=>", 2)
+		if len(pair) == 2 {
+			parsed = append(parsed, rules.Rule{
+				Pattern: strings.TrimSpace(pair[0]),
+				Value:   strings.TrimSpace(pair[1]),
+			})
+		} else if len(parsed) > 0 {
+			parsed[len(parsed)-1].Value += ", " + part
+		}
+	}
+
+	return parsed
+}
+
+// Parse an octal mode, like "644". Invalid or missing ones
+// are zero, which leaves permissions to the server.
+func parseMode(value string) os.FileMode {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0
+	}
+
+	return os.FileMode(mode)
+}
+
+// Parse permission rules in the form of "pattern => mode",
+// separated by commas. Rules with an invalid mode are left
+// out.
+func parsePermissions(value string) []Permission {
+	perms := []Permission{}
+
+	for _, rule := range parseRules(value) {
+		if mode := parseMode(rule.Value); mode != 0 {
+			perms = append(perms, Permission{Pattern: rule.Pattern, Mode: mode})
+		}
+	}
+
+	return perms
+}
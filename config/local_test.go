@@ -5,6 +5,8 @@ import (
 	"os"
 	"io/ioutil"
 	"reflect"
+	"path/filepath"
+	"github.com/fadion/steer/rules"
 )
 
 func TestLocalConfigDoesntExist(t *testing.T) {
@@ -60,6 +62,9 @@ func TestLocalConfigRead(t *testing.T) {
 
 	actual := contents.Sections[0]
 
+	// Paths are expanded as they're read.
+	home, _ := os.UserHomeDir()
+
 	expected := SectionConfig{
 		Scheme:     "ftp",
 		Section:    "production",
@@ -68,8 +73,33 @@ func TestLocalConfigRead(t *testing.T) {
 		Username:   "user",
 		Password:   "pass",
 		Privatekey: "",
+		Passphrase: "",
+		Cacert:     "",
+		Clientcert: "",
+		Clientkey:  "",
+		Skipverify: false,
+		Knownhosts: filepath.Join(home, ".ssh/known_hosts"),
+		Hostkey:    "",
+		Jumphosts:  []string{},
+		Jumpkeys:   []string{},
+		Bucket:     "",
+		Region:     "us-east-1",
+		Endpoint:   "",
+		Accesskey:  "",
+		Secretkey:  "",
+		Mimetypes:  true,
+		Cache:      []rules.Rule{},
 		Path:       "/",
+		Source:     "",
+		Map:        []Mapping{},
 		Branch:     "master",
+		Gitbackend: "auto",
+		Exmodules:  []string{},
+		Symlinks:   "copy",
+		Filemode:   0,
+		Dirmode:    0,
+		Perms:      []Permission{},
+		Safeupload: false,
 		Atomic:     false,
 		Reldir:     "releases",
 		Currdir:    "current",
@@ -85,3 +115,59 @@ func TestLocalConfigRead(t *testing.T) {
 		t.Fatalf("Config file contents not as expected.")
 	}
 }
+
+func TestCleanSource(t *testing.T) {
+	sources := map[string]string{
+		"":           "",
+		".":          "",
+		"/":          "",
+		"public":     "public",
+		"./public/":  "public",
+		"/site/dist": "site/dist",
+		"a/../dist":  "dist",
+	}
+
+	for source, expected := range sources {
+		if actual := cleanSource(source); actual != expected {
+			t.Fatalf("Expected %q for %q but got %q", expected, source, actual)
+		}
+	}
+}
+
+func TestParseMappings(t *testing.T) {
+	actual := parseMappings("app/ => /var/www/app, config/prod => /etc/myapp/, broken, cron=>home/deploy/cron")
+	expected := []Mapping{
+		{Local: "app", Remote: "/var/www/app"},
+		{Local: "config/prod", Remote: "/etc/myapp"},
+		{Local: "cron", Remote: "/home/deploy/cron"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	actual := parseRules("*.html => no-cache, assets/* => max-age=31536000, public, broken")
+	expected := []rules.Rule{
+		{Pattern: "*.html", Value: "no-cache"},
+		{Pattern: "assets/*", Value: "max-age=31536000, public, broken"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	actual := parsePermissions("bin/* => 755, *.php => 0640, storage/ => 775, broken => 999, cron.sh => x")
+	expected := []Permission{
+		{Pattern: "bin/*", Mode: 0755},
+		{Pattern: "*.php", Mode: 0640},
+		{Pattern: "storage/", Mode: 0775},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+}
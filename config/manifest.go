@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fadion/steer/server"
+)
+
+// Remote manifest of included files, with a hash of their
+// contents as last deployed. Includes aren't tracked by git,
+// so it's how changes to them are known.
+type Manifest struct {
+	conn *server.Connection
+	file string
+}
+
+// Initialise a new manifest.
+func NewManifest(conn *server.Connection) *Manifest {
+	return &Manifest{
+		conn: conn,
+		file: ".steer-includes",
+	}
+}
+
+// Read the hashes by file path.
+func (m *Manifest) Read() (map[string]string, error) {
+	hashes := map[string]string{}
+
+	contents, err := m.conn.Read(m.file)
+	if err != nil {
+		return hashes, err
+	}
+
+	if err = json.Unmarshal([]byte(contents), &hashes); err != nil {
+		return map[string]string{}, err
+	}
+
+	return hashes, nil
+}
+
+// Write the hashes by file path.
+func (m *Manifest) Write(hashes map[string]string) error {
+	contents, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return m.conn.Put(bytes.NewReader(contents), m.file)
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+	"reflect"
+	"github.com/fadion/steer/server"
+)
+
+func TestManifestReadWrite(t *testing.T) {
+	driver, err := server.ConnectFile(server.Params{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Connection couldn't be created: %s", err.Error())
+	}
+
+	manifest := NewManifest(server.Manage(driver))
+	if hashes, err := manifest.Read(); err == nil || len(hashes) != 0 {
+		t.Fatalf("A missing manifest should fail with no hashes.")
+	}
+
+	expected := map[string]string{"dist/app.js": "abc", "dist/new\nline.css": "def"}
+	if err = manifest.Write(expected); err != nil {
+		t.Fatalf("Manifest couldn't be written: %s", err.Error())
+	}
+
+	actual, err := manifest.Read()
+	if err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+}
+
+func TestManifestMalformed(t *testing.T) {
+	hashes, err := NewManifest(connection).Read()
+	if err == nil || len(hashes) != 0 {
+		t.Fatalf("A malformed manifest should fail with no hashes.")
+	}
+}
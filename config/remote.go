@@ -2,7 +2,6 @@ package config
 
 import (
 	"strings"
-	"os"
 	"github.com/fadion/steer/server"
 )
 
@@ -30,24 +29,7 @@ func (c *RemoteConfig) Read() (string, error) {
 
 // Write to the remote config.
 func (c *RemoteConfig) Write(rev string) error {
-	// Create a local copy of the revision file, so
-	// it can be copied to the server.
-	f, err := os.Create(c.file)
-	if err != nil {
-		return err
-	}
-
-	defer f.Close()
-	defer os.Remove(c.file)
-
-	_, err = f.WriteString(rev)
-	if err != nil {
-		return err
-	}
-
-	f.Sync()
-
-	if err = c.conn.Upload(c.file, c.file); err != nil {
+	if err := c.conn.Put(strings.NewReader(rev), c.file); err != nil {
 		return err
 	}
 
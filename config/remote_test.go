@@ -2,6 +2,10 @@ package config
 
 import (
 	"testing"
+	"os"
+	"io"
+	"io/ioutil"
+	"strings"
 	"github.com/fadion/steer/server"
 )
 
@@ -10,12 +14,20 @@ var revisioncontents = "mock-revision-contents"
 
 type MockServerDriver struct{}
 
-func (d *MockServerDriver) MkDir(path string) error               { return nil }
-func (d *MockServerDriver) Upload(path, destination string) error { return nil }
-func (d *MockServerDriver) Read(path string) (string, error)      { return revisioncontents, nil }
-func (d *MockServerDriver) Delete(path string) error              { return nil }
-func (d *MockServerDriver) Exec(command string) (string, error)   { return "", nil }
-func (d *MockServerDriver) Close()                                {}
+func (d *MockServerDriver) MkDir(path string) error                   { return nil }
+func (d *MockServerDriver) Put(r io.Reader, destination string) error { return nil }
+func (d *MockServerDriver) Get(path string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(revisioncontents)), nil
+}
+func (d *MockServerDriver) Delete(path string) error                  { return nil }
+func (d *MockServerDriver) RemoveDir(path string) error               { return nil }
+func (d *MockServerDriver) List(path string) ([]os.FileInfo, error)   { return nil, nil }
+func (d *MockServerDriver) Stat(path string) (os.FileInfo, error)     { return nil, os.ErrNotExist }
+func (d *MockServerDriver) Rename(from, to string) error              { return nil }
+func (d *MockServerDriver) Symlink(target, link string) error         { return nil }
+func (d *MockServerDriver) Chmod(path string, mode os.FileMode) error { return nil }
+func (d *MockServerDriver) Exec(command string) (string, error)       { return "", nil }
+func (d *MockServerDriver) Close()                                    {}
 
 func TestRemoteConfigRead(t *testing.T) {
 	rmt := NewRemote(connection)
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OpenSSH client configuration.
+type SshConfig struct {
+	file  string
+	hosts []sshHost
+}
+
+// A Host block with its patterns and options. Options keep
+// every value, as some keywords can be repeated.
+type sshHost struct {
+	patterns []string
+	options  map[string][]string
+}
+
+// Initialise a new SSH config.
+func NewSsh() *SshConfig {
+	return &SshConfig{
+		file: "~/.ssh/config",
+	}
+}
+
+// Read and parse the config file. A missing file isn't an
+// error, as most users don't have one.
+func (c *SshConfig) Read() error {
+	c.hosts = nil
+
+	file := expandHome(c.file)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+
+	return c.parse(file)
+}
+
+// Get the first value of an option for a host alias. As in
+// OpenSSH, the first obtained value wins.
+func (c *SshConfig) Get(alias, keyword string) string {
+	values := c.GetAll(alias, keyword)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Get every value of an option for a host alias, in the
+// order they're declared.
+func (c *SshConfig) GetAll(alias, keyword string) []string {
+	var values []string
+	keyword = strings.ToLower(keyword)
+
+	for _, host := range c.hosts {
+		if host.matches(alias) {
+			values = append(values, host.options[keyword]...)
+		}
+	}
+
+	return values
+}
+
+// Fill the connection details a section doesn't set with
+// those of its host alias.
+func (c *SshConfig) Resolve(section SectionConfig) SectionConfig {
+	alias := section.Host
+
+	if hostname := c.Get(alias, "HostName"); hostname != "" {
+		section.Host = strings.Replace(hostname, "%h", alias, -1)
+	}
+
+	if section.Port == 0 {
+		if port, err := strconv.Atoi(c.Get(alias, "Port")); err == nil {
+			section.Port = port
+		} else {
+			section.Port = 22
+		}
+	}
+
+	if section.Username == "" {
+		section.Username = c.Get(alias, "User")
+	}
+
+	// The first identity that exists is used, as there's
+	// room for a single private key.
+	if section.Privatekey == "" {
+		for _, identity := range c.GetAll(alias, "IdentityFile") {
+			identity = c.expandTokens(identity, alias, section)
+			if _, err := os.Stat(identity); err == nil {
+				section.Privatekey = identity
+				break
+			}
+		}
+	}
+
+	if len(section.Jumphosts) == 0 {
+		if proxy := c.Get(alias, "ProxyJump"); proxy != "" && proxy != "none" {
+			for _, hop := range strings.Split(proxy, ",") {
+				section.Jumphosts = append(section.Jumphosts, c.resolveHop(strings.TrimSpace(hop)))
+			}
+		}
+	}
+
+	return section
+}
+
+// Resolve a ProxyJump hop that refers to a host alias.
+func (c *SshConfig) resolveHop(hop string) string {
+	user, address := "", hop
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		user, address = address[:i], address[i+1:]
+	}
+
+	// Hops with an explicit port or IPv6 addresses are taken
+	// as they are.
+	if strings.ContainsAny(address, ":[") {
+		return hop
+	}
+
+	hostname := c.Get(address, "HostName")
+	if hostname == "" {
+		hostname = address
+	}
+
+	if user == "" {
+		user = c.Get(address, "User")
+	}
+
+	if port := c.Get(address, "Port"); port != "" {
+		hostname = fmt.Sprintf("%s:%s", hostname, port)
+	}
+
+	if user != "" {
+		return fmt.Sprintf("%s@%s", user, hostname)
+	}
+
+	return hostname
+}
+
+// Expand the tokens OpenSSH supports in paths.
+func (c *SshConfig) expandTokens(value, alias string, section SectionConfig) string {
+	home, _ := os.UserHomeDir()
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", section.Host,
+		"%n", alias,
+		"%p", strconv.Itoa(section.Port),
+		"%r", section.Username,
+	)
+
+	return expandHome(replacer.Replace(value))
+}
+
+// Parse a config file, following Include directives.
+func (c *SshConfig) parse(file string) error {
+	// Options before the first Host block apply to every host.
+	c.hosts = append(c.hosts, sshHost{patterns: []string{"*"}, options: map[string][]string{}})
+
+	return c.parseFile(file, len(c.hosts)-1, 0)
+}
+
+// Parse the lines of a file. Options go to the current block
+// until a Host or Match line starts a new one.
+func (c *SshConfig) parseFile(file string, current, depth int) error {
+	// Guard against includes that include themselves.
+	if depth > 16 {
+		return fmt.Errorf("%s includes too many files.", c.file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be read.", file)
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		keyword, args := parseSshLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			c.hosts = append(c.hosts, sshHost{patterns: args, options: map[string][]string{}})
+			current = len(c.hosts) - 1
+		case "match":
+			// Match blocks need to evaluate conditions that
+			// aren't known here, so they never match.
+			c.hosts = append(c.hosts, sshHost{options: map[string][]string{}})
+			current = len(c.hosts) - 1
+		case "include":
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(expandHome("~/.ssh"), pattern)
+				}
+
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					if err := c.parseFile(match, current, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if len(args) > 0 {
+				c.hosts[current].options[keyword] = append(c.hosts[current].options[keyword], strings.Join(args, " "))
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Split a line into a lowercase keyword and its arguments.
+// Both "Keyword value" and "Keyword=value" are valid.
+func parseSshLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.Index(rest[1:], "\"")
+			if closing < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:closing+1], rest[closing+2:]
+			}
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				arg, rest = rest, ""
+			} else {
+				arg, rest = rest[:end], rest[end:]
+			}
+		}
+
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	return keyword, args
+}
+
+// Check if a host alias matches the block's patterns. A
+// matching negated pattern excludes the host.
+func (h sshHost) matches(alias string) bool {
+	matched := false
+
+	for _, pattern := range h.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(alias)); ok {
+			if negated {
+				return false
+			}
+
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// Expand a leading ~ to the user's home directory. Paths
+// are expanded as they're read, so the rest of Steer takes
+// them as they are.
+func expandHome(file string) string {
+	if file != "~" && !strings.HasPrefix(file, "~/") {
+		return file
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return file
+	}
+
+	return filepath.Join(home, file[1:])
+}
+
+// Expand a leading ~ in each path.
+func expandHomes(files []string) []string {
+	for i := range files {
+		files[i] = expandHome(files[i])
+	}
+
+	return files
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"fmt"
+)
+
+var sshcontents = `# Defaults for every host.
+Host prod-web
+    HostName web.example.com
+    Port 2222
+    User deploy
+    IdentityFile %s
+    ProxyJump bastion
+
+Host bastion
+    HostName=bastion.example.com
+    User admin
+    Port 2200
+
+Host *.internal !secret.internal
+    User internal
+
+Host *
+    User fallback
+    Port 22
+`
+
+func writeSshConfig(t *testing.T) (*SshConfig, string) {
+	dir, err := ioutil.TempDir("", "steer-ssh")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	key := filepath.Join(dir, "id_rsa")
+	ioutil.WriteFile(key, []byte("key"), 0600)
+
+	cfg := NewSsh()
+	cfg.file = filepath.Join(dir, "config")
+	ioutil.WriteFile(cfg.file, []byte(fmt.Sprintf(sshcontents, key)), 0600)
+
+	if err := cfg.Read(); err != nil {
+		t.Fatalf("SSH config couldn't be read: %s", err.Error())
+	}
+
+	return cfg, dir
+}
+
+func TestSshConfigMissing(t *testing.T) {
+	cfg := NewSsh()
+	cfg.file = "./missing-ssh-config"
+
+	if err := cfg.Read(); err != nil {
+		t.Fatalf("A missing SSH config shouldn't be an error.")
+	}
+
+	actual := cfg.Resolve(SectionConfig{Host: "example.com"})
+	if actual.Host != "example.com" || actual.Port != 22 {
+		t.Fatalf("Expected example.com:22 but got %s:%d", actual.Host, actual.Port)
+	}
+}
+
+func TestSshConfigResolve(t *testing.T) {
+	cfg, dir := writeSshConfig(t)
+	defer os.RemoveAll(dir)
+
+	actual := cfg.Resolve(SectionConfig{Host: "prod-web"})
+	expected := SectionConfig{
+		Host:       "web.example.com",
+		Port:       2222,
+		Username:   "deploy",
+		Privatekey: filepath.Join(dir, "id_rsa"),
+		Jumphosts:  []string{"admin@bastion.example.com:2200"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+}
+
+func TestSshConfigSectionWins(t *testing.T) {
+	cfg, dir := writeSshConfig(t)
+	defer os.RemoveAll(dir)
+
+	actual := cfg.Resolve(SectionConfig{Host: "prod-web", Port: 22, Username: "me", Jumphosts: []string{"other"}})
+
+	if actual.Port != 22 || actual.Username != "me" || actual.Jumphosts[0] != "other" {
+		t.Fatalf("Section options should take precedence, but got %+v", actual)
+	}
+}
+
+func TestSshConfigPatterns(t *testing.T) {
+	cfg, dir := writeSshConfig(t)
+	defer os.RemoveAll(dir)
+
+	if user := cfg.Get("db.internal", "User"); user != "internal" {
+		t.Fatalf("Expected internal but got %s", user)
+	}
+
+	if user := cfg.Get("secret.internal", "User"); user != "fallback" {
+		t.Fatalf("Expected fallback but got %s", user)
+	}
+}
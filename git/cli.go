@@ -0,0 +1,279 @@
+package git
+
+import (
+	"os/exec"
+	"strings"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"path/filepath"
+)
+
+// Backend that runs the git binary.
+type cli struct {
+	dir    string
+	common string
+}
+
+// Streams the contents of a file from a commit.
+type blob struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	name   string
+	done   bool
+	err    error
+}
+
+// Initialise the backend for a directory, checking that
+// git is there and it's a repository.
+func newCli(dir string) (*cli, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git isn't installed. Install it or set 'gitbackend = native' to read the repository without it.")
+	}
+
+	c := &cli{dir: dir}
+	out, err := c.run("rev-parse", "--git-common-dir")
+	if err != nil {
+		return nil, fmt.Errorf("Current directory isn't a git repository.")
+	}
+
+	// The path may be relative to the repository.
+	c.common = strings.TrimSpace(string(out))
+	if !filepath.IsAbs(c.common) {
+		c.common = filepath.Join(dir, c.common)
+	}
+
+	return c, nil
+}
+
+// Directory shared by the worktrees of the repository.
+func (c *cli) CommonDir() string {
+	return c.common
+}
+
+// Resolve a revision to a commit hash.
+func (c *cli) Resolve(rev string) (string, error) {
+	out, err := c.run("rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Revision '%s' doesn't exist.", rev)
+	}
+
+	return strings.Trim(string(out), "\n "), nil
+}
+
+// List the files of a commit without diffing.
+func (c *cli) List(commit string) ([]File, error) {
+	out, err := c.run("ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []File
+
+	// Each entry is "mode type hash", a tab and the path,
+	// ending with a NUL byte. Names can have any character,
+	// even newlines.
+	for _, entry := range splitNul(out) {
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		list = append(list, File{
+			Name:      entry[tab+1:],
+			Operation: ADDED,
+			Mode:      strings.Fields(entry[:tab])[0],
+		})
+	}
+
+	return list, nil
+}
+
+// List submodules, which ls-tree shows as commits.
+func (c *cli) Modules(commit string) (map[string]string, error) {
+	out, err := c.run("ls-tree", "-r", "-z", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := map[string]string{}
+
+	// Each entry is "mode type hash", a tab and the path.
+	for _, entry := range splitNul(out) {
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		fields := strings.Fields(entry[:tab])
+		if len(fields) == 3 && fields[1] == "commit" {
+			modules[entry[tab+1:]] = fields[2]
+		}
+	}
+
+	return modules, nil
+}
+
+// List files by running diff.
+func (c *cli) Diff(from, to string) ([]File, error) {
+	out, err := c.run("diff", "--raw", "-z", "-M", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := splitNul(out)
+	var list []File
+
+	// Each change is the modes, hashes and status followed by
+	// the path, all ending with a NUL byte. Renames and copies
+	// have a similarity score and both paths. Ie:
+	// :100644 100644 1a2b 3c4d M\0file.ext\0
+	// :100644 100644 1a2b 1a2b R095\0old.ext\0new.ext\0
+	for i := 0; i+1 < len(fields); i += 2 {
+		meta := strings.Fields(fields[i])
+		if len(meta) != 5 || meta[4] == "" {
+			break
+		}
+
+		status := meta[4]
+
+		operat := operation[rune(status[0])]
+		if operat == "" {
+			operat = UNKNOWN
+		}
+
+		// Deleted files only have the old mode.
+		mode := meta[1]
+		if operat == DELETED {
+			mode = strings.TrimPrefix(meta[0], ":")
+		}
+
+		file := File{
+			Name:      fields[i+1],
+			Operation: operat,
+			Mode:      mode,
+		}
+
+		if operat == RENAMED || operat == COPIED {
+			if i+2 >= len(fields) {
+				break
+			}
+
+			file.OldName, file.Name = fields[i+1], fields[i+2]
+			file.Similarity, _ = strconv.Atoi(status[1:])
+			i++
+		}
+
+		list = append(list, file)
+	}
+
+	return list, nil
+}
+
+// Stream a file from a commit.
+func (c *cli) Blob(commit, path string) (io.ReadCloser, error) {
+	cmd := exec.Command("git", "-C", c.dir, "cat-file", "blob", fmt.Sprintf("%s:%s", commit, path))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blob{cmd: cmd, stdout: stdout, stderr: &bytes.Buffer{}, name: path}
+	cmd.Stderr = b.stderr
+
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("git couldn't be run. Make sure it's installed.")
+	}
+
+	return b, nil
+}
+
+// Fetch from origin. Shallow clones are deepened to the
+// full history. If the revision is still missing, it's
+// fetched by itself, which some hosts allow.
+func (c *cli) Fetch(rev string) error {
+	args := []string{"fetch", "--quiet", "origin"}
+	if out, _ := c.run("rev-parse", "--is-shallow-repository"); strings.TrimSpace(string(out)) == "true" {
+		args = []string{"fetch", "--quiet", "--unshallow", "origin"}
+	}
+
+	if _, err := c.run(args...); err != nil {
+		return err
+	}
+
+	if _, err := c.Resolve(rev); err == nil {
+		return nil
+	}
+
+	_, err := c.run("fetch", "--quiet", "origin", rev)
+	return err
+}
+
+// Run git in the repository and return its output. Errors
+// carry what git had to say about them.
+func (c *cli) run(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", c.dir}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		return nil, fmt.Errorf("'git %s' failed: %s", args[0], msg)
+	}
+
+	return out, nil
+}
+
+// Split NUL terminated output into its fields.
+func splitNul(out []byte) []string {
+	fields := strings.Split(string(out), "\x00")
+
+	// Output ends with a NUL byte, leaving an empty field.
+	if len(fields) > 0 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+
+	return fields
+}
+
+// Read from git. Failures, like a path that isn't in the
+// commit, are only known once git exits.
+func (b *blob) Read(p []byte) (int, error) {
+	n, err := b.stdout.Read(p)
+	if err == io.EOF {
+		if werr := b.wait(); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// Stop reading.
+func (b *blob) Close() error {
+	b.stdout.Close()
+	b.wait()
+
+	return nil
+}
+
+// Wait for git to exit.
+func (b *blob) wait() error {
+	if b.done {
+		return b.err
+	}
+
+	b.done = true
+	if err := b.cmd.Wait(); err != nil {
+		b.err = fmt.Errorf("%s couldn't be read from git. %s", b.name, strings.TrimSpace(b.stderr.String()))
+	}
+
+	return b.err
+}
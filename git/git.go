@@ -2,21 +2,90 @@ package git
 
 import (
 	"os/exec"
-	"strings"
-	"bytes"
-	"bufio"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 )
 
 // Version control.
 type Version struct {
-	Branch string
+	Branch  string
+	head    string
+	dir     string
+	kind    string
+	backend Backend
+	exclude map[string]bool
+	mutex   sync.Mutex
+	modules map[string]map[string]string
+	subs    map[string]*Version
+	trees   map[string]map[string]File
+	lfs     map[string]*lfsAttributes
+}
+
+// Reads the history of a repository.
+type Backend interface {
+	// Resolve a branch, tag or hash to a commit hash.
+	Resolve(rev string) (string, error)
+	// List the files of a commit.
+	List(commit string) ([]File, error)
+	// List the files that changed between two commits.
+	Diff(from, to string) ([]File, error)
+	// Read a file as it is in a commit.
+	Blob(commit, path string) (io.ReadCloser, error)
+	// Fetch history from origin, so a revision can be found.
+	Fetch(rev string) error
+	// List the submodules of a commit with the commit each
+	// one is at, by path.
+	Modules(commit string) (map[string]string, error)
+	// Directory with objects and refs, shared by worktrees.
+	CommonDir() string
+}
+
+// Returned when a revision isn't in the local history, as
+// after a force push or in a shallow clone.
+type UnknownRevisionError struct {
+	Revision string
+}
+
+func (e *UnknownRevisionError) Error() string {
+	return fmt.Sprintf("Revision %s isn't in the local history.", e.Revision)
+}
+
+// Check if an error is about an unknown revision.
+func IsUnknownRevision(err error) bool {
+	_, ok := err.(*UnknownRevisionError)
+	return ok
 }
 
-// Represents a local file.
+// Represents a local file. Files that aren't tracked, like
+// includes, are read from the working tree. Renames and
+// copies have the original name and how similar they are,
+// from 0 to 100. Source is the path in the repository, when
+// it's deployed with a different name. Mode is git's, like
+// 100644 for files and 120000 for symlinks.
 type File struct {
-	Name      string
-	Operation string
+	Name       string
+	Operation  string
+	Worktree   bool
+	OldName    string
+	Similarity int
+	Source     string
+	Mode       string
+}
+
+// Check if the file is a symbolic link.
+func (f File) IsSymlink() bool {
+	return f.Mode == "120000"
+}
+
+// Path of the file in the repository.
+func (f File) Path() string {
+	if f.Source != "" {
+		return f.Source
+	}
+
+	return f.Name
 }
 
 const (
@@ -39,109 +108,147 @@ var operation = map[rune]string{
 	'U': UNKNOWN,
 }
 
-// Initialise a Version struct.
-func New(branch string) (*Version, error) {
-	v := Version{Branch: branch}
-	if err := v.Checkout(branch); err != nil {
+// Initialise a Version struct. The branch is never checked
+// out, so the working copy is left as it is. The backend is
+// "cli", "native" or "auto", which uses git when installed.
+func New(branch, backend string) (*Version, error) {
+	v, err := open(".", backend)
+	if err != nil {
 		return nil, err
 	}
 
-	return &v, nil
-}
+	v.Branch = branch
 
-// List files that have changed.
-func (v *Version) Changes(remote, local string) []File {
-	if remote == "" {
-		return v.lsfiles()
-	} else {
-		return v.diff(remote, local)
+	// Branches that only exist on the remote, as in most CI
+	// clones, are found through origin.
+	for _, ref := range []string{branch, "origin/" + branch} {
+		if head, err := v.backend.Resolve(ref); err == nil {
+			v.head = head
+			return v, nil
+		}
 	}
+
+	return nil, fmt.Errorf("Branch '%s' doesn't exist.", branch)
 }
 
-// Get the HEAD commit hash.
-func (v *Version) RefHead() string {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	out, _ := cmd.Output()
+// Open the repository in a directory.
+func open(dir, backend string) (*Version, error) {
+	b, err := newBackend(dir, backend)
+	if err != nil {
+		return nil, err
+	}
 
-	return strings.Trim(string(out), "\n ")
+	return &Version{
+		dir:     dir,
+		kind:    backend,
+		backend: b,
+		exclude: map[string]bool{},
+		modules: map[string]map[string]string{},
+		subs:    map[string]*Version{},
+		trees:   map[string]map[string]File{},
+		lfs:     map[string]*lfsAttributes{},
+	}, nil
 }
 
-// Checkout to a branch.
-func (v *Version) Checkout(branch string) error {
-	cmd := exec.Command("git", "checkout", branch)
-	out, _ := cmd.Output()
-	lines := strings.Split(strings.Trim(string(out), "\n "), "\n")
-
-	if len(lines) == 0 {
-		return fmt.Errorf("No output from 'git checkout'. This shouldn't have happened.")
+// Pick a backend by name.
+func newBackend(dir, name string) (Backend, error) {
+	switch name {
+	case "cli":
+		return newCli(dir)
+	case "native":
+		return newNative(dir)
 	}
 
-	// Non existing branches or those that have local modifications,
-	// produce an error message as in: "error:..."
-	if strings.Contains(lines[0], "error:") {
-		return fmt.Errorf("Branch '%s' doesn't exist or modifications need to be stashed.", branch)
+	if _, err := exec.LookPath("git"); err == nil {
+		return newCli(dir)
 	}
 
-	return nil
+	return newNative(dir)
 }
 
-// List files without diffing.
-func (v *Version) lsfiles() []File {
-	cmd := exec.Command("git", "-c", "core.quotepath=false", "ls-files")
-	cmdOut := &bytes.Buffer{}
-	cmd.Stdout = cmdOut
-	cmd.Run()
+// Leave submodules out of the changes, by path.
+func (v *Version) ExcludeModules(paths []string) {
+	for _, path := range paths {
+		v.exclude[strings.Trim(path, "/")] = true
+	}
+}
 
-	scanner := bufio.NewScanner(cmdOut)
-	var list []File
+// List files that have changed.
+func (v *Version) Changes(remote, local string) ([]File, error) {
+	// Without a commit, it's the tip of the branch.
+	if local == "" {
+		local = v.head
+	}
+
+	if _, err := v.backend.Resolve(local); err != nil {
+		return nil, err
+	}
+
+	var files []File
+	var err error
+
+	if remote == "" {
+		files, err = v.backend.List(local)
+	} else {
+		// A diff with a revision that doesn't exist would fail,
+		// so it's reported for the caller to decide what to do.
+		if _, err := v.backend.Resolve(remote); err != nil {
+			return nil, &UnknownRevisionError{Revision: remote}
+		}
 
-	// Each line represents a different file.
-	for scanner.Scan() {
-		line := scanner.Text()
+		files, err = v.backend.Diff(remote, local)
+	}
 
-		list = append(list, File{
-			Name:      strings.TrimSpace(line),
-			Operation: ADDED,
-		})
+	if err != nil {
+		return nil, err
 	}
 
-	return list
+	return v.expandModules(files, remote, local)
 }
 
-// List files by running diff.
-func (v *Version) diff(remote, local string) []File {
-	// Set the local commit to HEAD just for consistence,
-	// as it doesn't make much of a difference.
-	if local == "" {
-		local = "HEAD"
+// Fetch history until a revision is known.
+func (v *Version) Fetch(rev string) error {
+	if err := v.backend.Fetch(rev); err != nil {
+		return err
 	}
 
-	cmd := exec.Command("git", "-c", "core.quotepath=false", "diff", "--name-status", "--no-renames", remote, local)
-	cmdOut := &bytes.Buffer{}
-	cmd.Stdout = cmdOut
-	cmd.Run()
+	if _, err := v.backend.Resolve(rev); err != nil {
+		return &UnknownRevisionError{Revision: rev}
+	}
 
-	scanner := bufio.NewScanner(cmdOut)
-	var list []File
+	return nil
+}
 
-	// Each line represents a different file with the type
-	// of change. Ie:
-	// M file.ext
-	// A file2.ext
-	for scanner.Scan() {
-		line := scanner.Text()
-		op, file := line[0], strings.TrimSpace(line[1:])
+// Get the commit hash at the tip of the branch.
+func (v *Version) RefHead() string {
+	return v.head
+}
+
+// Read a file as it is in a commit, so what's deployed
+// matches the revision and not the working tree. Files that
+// Git LFS tracks are replaced by the object they point to.
+func (v *Version) Blob(commit, path string) (io.ReadCloser, error) {
+	modules, err := v.modulesAt(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files in submodules are read from their own repository.
+	for module, hash := range modules {
+		if strings.HasPrefix(path, module+"/") {
+			sub, err := v.module(module)
+			if err != nil {
+				return nil, err
+			}
 
-		operat := operation[rune(op)]
-		if operat == "" {
-			operat = UNKNOWN
+			return sub.Blob(hash, path[len(module)+1:])
 		}
+	}
 
-		list = append(list, File{
-			Name:      file,
-			Operation: operat,
-		})
+	blob, err := v.backend.Blob(commit, path)
+	if err != nil || !v.isLfs(commit, path) {
+		return blob, err
 	}
 
-	return list
+	return v.resolveLfs(blob, path)
 }
@@ -0,0 +1,458 @@
+package git
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Names that break line based parsing or get quoted by git.
+var awkwardNames = []string{
+	"plain.txt",
+	" leading space.txt",
+	"trailing space.txt ",
+	"tab\tin name.txt",
+	"new\nline.txt",
+	"ünïcödé/ファイル.txt",
+	"quote\"and\\backslash.txt",
+	"-dash.txt",
+}
+
+// Create a repository in a temp directory and move into it.
+// The returned function moves back and removes it.
+func fixtureRepo(t *testing.T) func() {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't allow some of the file names.")
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed.")
+	}
+
+	dir, err := ioutil.TempDir("", "steer-git")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	cwd, _ := os.Getwd()
+	os.Chdir(dir)
+
+	run(t, "init", "-q")
+	run(t, "symbolic-ref", "HEAD", "refs/heads/master")
+	run(t, "config", "user.email", "steer@example.com")
+	run(t, "config", "user.name", "Steer")
+
+	return func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
+
+// Run a git command in the current repository.
+func run(t *testing.T, args ...string) {
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+	}
+}
+
+// Write a file, creating its directory.
+func write(t *testing.T, name, contents string) {
+	os.MkdirAll(filepath.Dir(name), 0755)
+	if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatalf("%q couldn't be written.", name)
+	}
+}
+
+// Commit everything in the working tree.
+func commit(t *testing.T, message string) {
+	run(t, "add", "-A")
+	run(t, "commit", "-q", "-m", message)
+}
+
+// Run a test against each backend.
+func eachBackend(t *testing.T, fn func(*testing.T, string)) {
+	for _, backend := range []string{"cli", "native"} {
+		t.Run(backend, func(t *testing.T) {
+			fn(t, backend)
+		})
+	}
+}
+
+// List changes, failing the test on errors.
+func changes(t *testing.T, vcs *Version, remote, local string) []File {
+	files, err := vcs.Changes(remote, local)
+	if err != nil {
+		t.Fatalf("Changes couldn't be listed: %s", err.Error())
+	}
+
+	return files
+}
+
+func names(files []File) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.Name)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+func TestListAwkwardNames(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		for _, name := range awkwardNames {
+			write(t, name, name)
+		}
+
+		commit(t, "first")
+
+		vcs, err := New("master", backend)
+		if err != nil {
+			t.Fatalf("Version couldn't be initialised: %s", err.Error())
+		}
+
+		expected := append([]string{}, awkwardNames...)
+		sort.Strings(expected)
+		actual := names(changes(t, vcs, "", ""))
+
+		if strings.Join(actual, "|") != strings.Join(expected, "|") {
+			t.Fatalf("Expected %q but got %q", expected, actual)
+		}
+	})
+}
+
+func TestDiffAwkwardNames(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		for _, name := range awkwardNames {
+			write(t, name, strings.Repeat(name+"\n", 20))
+		}
+
+		commit(t, "first")
+		vcs, _ := New("master", backend)
+		first := vcs.RefHead()
+
+		write(t, "new\nline.txt", "changed")
+		os.Remove(" leading space.txt")
+		os.Mkdir("moved", 0755)
+		os.Rename("tab\tin name.txt", "moved/tab\tin name.txt")
+		write(t, "added\n.txt", "added")
+		commit(t, "second")
+
+		vcs, _ = New("master", backend)
+		files := changes(t, vcs, first, "")
+
+		expected := map[string]File{
+			"new\nline.txt":          {Operation: MODIFIED},
+			" leading space.txt":     {Operation: DELETED},
+			"moved/tab\tin name.txt": {Operation: RENAMED, OldName: "tab\tin name.txt", Similarity: 100},
+			"added\n.txt":            {Operation: ADDED},
+		}
+
+		if len(files) != len(expected) {
+			t.Fatalf("Expected %d changes but got %d: %q", len(expected), len(files), names(files))
+		}
+
+		for _, file := range files {
+			e, ok := expected[file.Name]
+			if !ok || e.Operation != file.Operation || e.OldName != file.OldName || e.Similarity != file.Similarity {
+				t.Fatalf("Unexpected change %+v", file)
+			}
+		}
+	})
+}
+
+func TestRenameWithEdits(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "old.txt", strings.Repeat("line\n", 50))
+		commit(t, "first")
+		vcs, _ := New("master", backend)
+		first := vcs.RefHead()
+
+		os.Remove("old.txt")
+		write(t, "new.txt", strings.Repeat("line\n", 50)+"edit\n")
+		commit(t, "second")
+
+		vcs, _ = New("master", backend)
+		files := changes(t, vcs, first, "")
+
+		if len(files) != 1 || files[0].Operation != RENAMED || files[0].OldName != "old.txt" {
+			t.Fatalf("Expected a rename from old.txt, but got %+v", files)
+		}
+
+		if files[0].Similarity == 0 || files[0].Similarity == 100 {
+			t.Fatalf("Expected a partial similarity, but got %d", files[0].Similarity)
+		}
+	})
+}
+
+func TestBlobFromCommit(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		name := "new\nline.txt"
+		write(t, name, "committed")
+		commit(t, "first")
+
+		// Uncommitted changes aren't read.
+		write(t, name, "working tree")
+
+		vcs, _ := New("master", backend)
+		blob, err := vcs.Blob(vcs.RefHead(), name)
+		if err != nil {
+			t.Fatalf("Blob couldn't be read: %s", err.Error())
+		}
+
+		contents, err := ioutil.ReadAll(blob)
+		blob.Close()
+		if err != nil || string(contents) != "committed" {
+			t.Fatalf("Expected committed but got %s", contents)
+		}
+
+		// Backends may fail when opening or only once read.
+		blob, err = vcs.Blob(vcs.RefHead(), "missing.txt")
+		if err == nil {
+			_, err = ioutil.ReadAll(blob)
+			blob.Close()
+		}
+
+		if err == nil {
+			t.Fatalf("Reading a missing file should fail.")
+		}
+	})
+}
+
+func TestMissingBranch(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "file.txt", "file")
+		commit(t, "first")
+
+		if _, err := New("missing", backend); err == nil {
+			t.Fatalf("Missing branch should return an error.")
+		}
+	})
+}
+
+func TestUnknownRevision(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "file.txt", "file")
+		commit(t, "first")
+
+		vcs, _ := New("master", backend)
+		_, err := vcs.Changes(strings.Repeat("ab", 20), "")
+		if !IsUnknownRevision(err) {
+			t.Fatalf("Expected an unknown revision error, but got %v", err)
+		}
+	})
+}
+
+func TestFetchShallowClone(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "file.txt", "one")
+		commit(t, "first")
+		vcs, _ := New("master", backend)
+		first := vcs.RefHead()
+
+		write(t, "file.txt", "two")
+		commit(t, "second")
+
+		origin, _ := os.Getwd()
+		run(t, "clone", "-q", "--depth", "1", "file://"+origin, "clone")
+		os.Chdir("clone")
+
+		vcs, err := New("master", backend)
+		if err != nil {
+			t.Fatalf("Version couldn't be initialised: %s", err.Error())
+		}
+
+		if _, err = vcs.Changes(first, ""); !IsUnknownRevision(err) {
+			t.Fatalf("Expected the first commit to be missing from the clone, but got %v", err)
+		}
+
+		if err = vcs.Fetch(first); err != nil {
+			t.Fatalf("History couldn't be fetched: %s", err.Error())
+		}
+
+		files := changes(t, vcs, first, "")
+		if len(files) != 1 || files[0].Name != "file.txt" || files[0].Operation != MODIFIED {
+			t.Fatalf("Expected file.txt to be modified, but got %+v", files)
+		}
+	})
+}
+
+// A force push leaves the deployed commit on no branch, so
+// it's fetched by its hash.
+func TestFetchDroppedCommit(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "file.txt", "one")
+		commit(t, "first")
+
+		// The clone is kept out of the repository, so it isn't
+		// committed with the next change.
+		clone, err := ioutil.TempDir("", "steer-clone")
+		if err != nil {
+			t.Fatalf("Temp directory couldn't be created.")
+		}
+
+		defer os.RemoveAll(clone)
+
+		origin, _ := os.Getwd()
+		run(t, "clone", "-q", "file://"+origin, clone)
+
+		write(t, "file.txt", "two")
+		commit(t, "dropped")
+		vcs, _ := New("master", backend)
+		dropped := vcs.RefHead()
+
+		run(t, "reset", "-q", "--hard", "HEAD~1")
+		run(t, "config", "uploadpack.allowAnySHA1InWant", "true")
+		os.Chdir(clone)
+
+		vcs, err = New("master", backend)
+		if err != nil {
+			t.Fatalf("Version couldn't be initialised: %s", err.Error())
+		}
+
+		if err = vcs.Fetch(dropped); err != nil {
+			t.Fatalf("Commit couldn't be fetched: %s", err.Error())
+		}
+
+		files := changes(t, vcs, dropped, "")
+		if len(files) != 1 || files[0].Name != "file.txt" || files[0].Operation != MODIFIED {
+			t.Fatalf("Expected file.txt to be modified, but got %+v", files)
+		}
+	})
+}
+
+func TestFileModes(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "file.txt", "file")
+		write(t, "script.sh", "#!/bin/sh")
+		os.Chmod("script.sh", 0755)
+		if err := os.Symlink("file.txt", "link"); err != nil {
+			t.Skip("Symlinks can't be created.")
+		}
+
+		commit(t, "first")
+
+		vcs, _ := New("master", backend)
+		first := vcs.RefHead()
+
+		modes := map[string]string{}
+		for _, file := range changes(t, vcs, "", "") {
+			modes[file.Name] = file.Mode
+		}
+
+		expected := map[string]string{"file.txt": "100644", "script.sh": "100755", "link": "120000"}
+		if !reflect.DeepEqual(modes, expected) {
+			t.Fatalf("Expected %v but got %v", expected, modes)
+		}
+
+		os.Remove("link")
+		os.Symlink("script.sh", "link")
+		os.Remove("script.sh")
+		commit(t, "second")
+
+		vcs, _ = New("master", backend)
+		for _, file := range changes(t, vcs, first, "") {
+			switch {
+			case file.Name == "link" && (file.Operation != MODIFIED || !file.IsSymlink()):
+				t.Fatalf("Expected link to be a modified symlink, but got %+v", file)
+			case file.Name == "script.sh" && (file.Operation != DELETED || file.Mode != "100755"):
+				t.Fatalf("Expected script.sh to be deleted with its mode, but got %+v", file)
+			}
+		}
+	})
+}
+
+func TestSubmodules(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		// The submodule's upstream lives next to the files, but
+		// isn't part of the repository.
+		write(t, "upstream/a.txt", "one")
+		write(t, "upstream/b.txt", "b")
+		write(t, ".git/info/exclude", "upstream\n")
+		run(t, "-C", "upstream", "init", "-q")
+		run(t, "-C", "upstream", "symbolic-ref", "HEAD", "refs/heads/master")
+		run(t, "-C", "upstream", "add", "-A")
+		run(t, "-C", "upstream", "-c", "user.name=Steer", "-c", "user.email=steer@example.com", "commit", "-q", "-m", "first")
+
+		upstream, _ := filepath.Abs("upstream")
+		write(t, "index.php", "index")
+		run(t, "-c", "protocol.file.allow=always", "submodule", "add", "-q", upstream, "lib")
+		commit(t, "first")
+
+		vcs, _ := New("master", backend)
+		first := vcs.RefHead()
+
+		actual := names(changes(t, vcs, "", ""))
+		expected := []string{".gitmodules", "index.php", "lib/a.txt", "lib/b.txt"}
+		if strings.Join(actual, "|") != strings.Join(expected, "|") {
+			t.Fatalf("Expected %q but got %q", expected, actual)
+		}
+
+		write(t, "upstream/a.txt", "two")
+		run(t, "-C", "upstream", "-c", "user.name=Steer", "-c", "user.email=steer@example.com", "commit", "-q", "-am", "second")
+		run(t, "-C", "lib", "pull", "-q", "origin", "master")
+		commit(t, "second")
+
+		vcs, _ = New("master", backend)
+		files := changes(t, vcs, first, "")
+		if len(files) != 1 || files[0].Name != "lib/a.txt" || files[0].Operation != MODIFIED {
+			t.Fatalf("Expected lib/a.txt to be modified, but got %+v", files)
+		}
+
+		blob, err := vcs.Blob(vcs.RefHead(), "lib/a.txt")
+		if err != nil {
+			t.Fatalf("Blob couldn't be read from the submodule: %s", err.Error())
+		}
+
+		contents, _ := ioutil.ReadAll(blob)
+		blob.Close()
+		if string(contents) != "two" {
+			t.Fatalf("Expected two but got %s", contents)
+		}
+
+		vcs.ExcludeModules([]string{"lib/"})
+		actual = names(changes(t, vcs, "", ""))
+		expected = []string{".gitmodules", "index.php"}
+		if strings.Join(actual, "|") != strings.Join(expected, "|") {
+			t.Fatalf("Expected %q but got %q", expected, actual)
+		}
+	})
+}
+
+func TestSplitNul(t *testing.T) {
+	actual := splitNul([]byte("a\x00b c\x00\x00"))
+	if len(actual) != 3 || actual[0] != "a" || actual[1] != "b c" || actual[2] != "" {
+		t.Fatalf("Expected [a, b c, ''] but got %q", actual)
+	}
+
+	if len(splitNul(nil)) != 0 {
+		t.Fatalf("Expected no fields from empty output.")
+	}
+}
@@ -0,0 +1,195 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches paths against patterns with the semantics of
+// .gitignore: "*", "?", "[a-z]", "**", negation with "!",
+// patterns anchored by a slash and directory patterns
+// ending with one.
+// Based on https://git-scm.com/docs/gitignore
+type Matcher struct {
+	patterns []pattern
+}
+
+// A compiled pattern.
+type pattern struct {
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Initialise a matcher from patterns, one per item. Blank
+// items and comments are skipped.
+func NewMatcher(lines []string) *Matcher {
+	m := &Matcher{}
+
+	for _, line := range lines {
+		if p, ok := compilePattern(line); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+
+	return m
+}
+
+// Patterns of the files that have an attribute set in the
+// contents of a .gitattributes file. Unsetting it later, or
+// giving it another value, negates the pattern.
+func ParseAttributes(contents, attribute string) []string {
+	var lines []string
+
+	name := attribute
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name = name[:i]
+	}
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, attr := range fields[1:] {
+			switch {
+			case attr == attribute:
+				lines = append(lines, fields[0])
+			case attr == "-"+name, attr == "!"+name, strings.HasPrefix(attr, name+"="):
+				lines = append(lines, "!"+fields[0])
+			}
+		}
+	}
+
+	return lines
+}
+
+// Check if there are no patterns.
+func (m *Matcher) Empty() bool {
+	return len(m.patterns) == 0
+}
+
+// Check if a path, relative to where patterns apply, is
+// matched. A file inside a matched directory is matched as
+// well and, as in git, it can't be negated.
+func (m *Matcher) Match(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i := range parts {
+		current := strings.Join(parts[:i+1], "/")
+		isDir := i < len(parts)-1
+
+		if m.matchOne(current, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match a single path, where the last pattern to match
+// decides.
+func (m *Matcher) matchOne(path string, isDir bool) bool {
+	matched := false
+
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		if p.regex.MatchString(path) {
+			matched = !p.negate
+		}
+	}
+
+	return matched
+}
+
+// Compile a pattern into a regular expression.
+func compilePattern(line string) (pattern, bool) {
+	p := pattern{}
+
+	line = strings.TrimRight(line, "\r")
+
+	// Trailing spaces are ignored, unless escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return p, false
+	}
+
+	// A slash at the start or in the middle anchors the
+	// pattern to the root. Otherwise, it matches at any level.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			expr += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(line[i:], "/**") && i+3 == len(line):
+			expr += "/.*"
+			i += 2
+		case line[i:] == "**" && i == 0:
+			expr += ".*"
+			i++
+		case c == '*':
+			expr += "[^/]*"
+		case c == '?':
+			expr += "[^/]"
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr += regexp.QuoteMeta(string(line[i]))
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				expr += regexp.QuoteMeta("[")
+				continue
+			}
+
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expr += "[" + strings.Replace(class, "\\", "\\\\", -1) + "]"
+			i += end + 1
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+
+	regex, err := regexp.Compile("(?s)" + expr + "$")
+	if err != nil {
+		return p, false
+	}
+
+	p.regex = regex
+
+	return p, true
+}
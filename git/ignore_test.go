@@ -0,0 +1,92 @@
+package git
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher([]string{
+		"# comment",
+		"",
+		"tests",
+		"*.map",
+		"/build/",
+		"docs/*.md",
+		"!docs/README.md",
+		"logs/**/debug.log",
+		"cache/**",
+		"**/tmp",
+		"file[0-9].txt",
+		"!keep.map",
+		"\\#hash",
+		"trailing\\ ",
+	})
+
+	cases := map[string]bool{
+		"tests":                true,
+		"tests/unit/a_test.go": true,
+		"src/tests/b.go":       true,
+		"testsuite/a.go":       false,
+		"app.js.map":           true,
+		"assets/js/app.js.map": true,
+		"keep.map":             false,
+		"build/out.js":         true,
+		"build":                false,
+		"src/build/out.js":     false,
+		"docs/guide.md":        true,
+		"docs/README.md":       false,
+		"docs/sub/guide.md":    false,
+		"logs/debug.log":       true,
+		"logs/a/b/debug.log":   true,
+		"other/logs/debug.log": false,
+		"cache/x/y":            true,
+		"cache":                false,
+		"a/b/tmp/c":            true,
+		"new\nline/tests":      true,
+		"cache/new\nline":      true,
+		"file1.txt":            true,
+		"fileA.txt":            false,
+		"#hash":                true,
+		"trailing ":            true,
+		"index.php":            false,
+	}
+
+	for path, expected := range cases {
+		if actual := m.Match(path); actual != expected {
+			t.Errorf("Expected %q to match: %v", path, expected)
+		}
+	}
+}
+
+func TestMatcherExcludedParent(t *testing.T) {
+	// Files can't be re-included if their directory is
+	// excluded.
+	m := NewMatcher([]string{"vendor/", "!vendor/keep.php"})
+	if !m.Match("vendor/keep.php") {
+		t.Fatalf("Files in an excluded directory can't be negated.")
+	}
+
+	m = NewMatcher([]string{"vendor/*", "!vendor/keep.php"})
+	if m.Match("vendor/keep.php") || !m.Match("vendor/other.php") {
+		t.Fatalf("Files matched by a wildcard can be negated.")
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	contents := "# comment\n*.txt text\n/tests export-ignore\n.gitattributes export-ignore eol=lf\ndocs -export-ignore\n"
+	actual := ParseAttributes(contents, "export-ignore")
+	expected := []string{"/tests", ".gitattributes", "!docs"}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %q but got %q", expected, actual)
+	}
+
+	contents = "*.png filter=lfs\n/logo.png -filter\nraw/*.png filter=other\n"
+	actual = ParseAttributes(contents, "filter=lfs")
+	expected = []string{"*.png", "!/logo.png", "!raw/*.png"}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %q but got %q", expected, actual)
+	}
+}
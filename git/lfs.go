@@ -0,0 +1,236 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Patterns of the files tracked by Git LFS, compiled once.
+type lfsAttributes struct {
+	lines   []string
+	matcher *Matcher
+}
+
+// Pointers are small text files, well below this size.
+// Based on https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+const lfsPointerMax = 1024
+
+// Error for a Git LFS object that isn't in the local store.
+type MissingLfsError struct {
+	Path string
+	Oid  string
+}
+
+func (e *MissingLfsError) Error() string {
+	return fmt.Sprintf("Git LFS object of %s isn't in the local store.", e.Path)
+}
+
+// Check if the error is for a missing Git LFS object.
+func IsMissingLfs(err error) bool {
+	_, ok := err.(*MissingLfsError)
+	return ok
+}
+
+// Parse a Git LFS pointer, returning the object id and size.
+func parsePointer(data []byte) (string, int64, bool) {
+	if len(data) >= lfsPointerMax || !bytes.HasPrefix(data, []byte("version https://git-lfs.github.com/spec/")) {
+		return "", 0, false
+	}
+
+	var oid string
+	size := int64(-1)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "oid sha256:"):
+			oid = line[len("oid sha256:"):]
+		case strings.HasPrefix(line, "size "):
+			parsed, err := strconv.ParseInt(line[len("size "):], 10, 64)
+			if err != nil {
+				return "", 0, false
+			}
+
+			size = parsed
+		}
+	}
+
+	if len(oid) != 64 || !isHex(oid) || size < 0 {
+		return "", 0, false
+	}
+
+	return oid, size, true
+}
+
+// Path of a Git LFS object in the local store.
+func (v *Version) lfsObject(oid string) string {
+	return filepath.Join(v.backend.CommonDir(), "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// Replace a blob with its Git LFS object, if it's a pointer.
+func (v *Version) resolveLfs(blob io.ReadCloser, path string) (io.ReadCloser, error) {
+	reader := bufio.NewReaderSize(blob, lfsPointerMax)
+
+	head, err := reader.Peek(lfsPointerMax)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		blob.Close()
+		return nil, err
+	}
+
+	oid, size, ok := parsePointer(head)
+	if !ok {
+		var rest io.Reader = reader
+
+		// Small blobs are read whole, and reading past the end
+		// again isn't safe for every backend.
+		if err == io.EOF {
+			rest = bytes.NewReader(head)
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{rest, blob}, nil
+	}
+
+	blob.Close()
+
+	object, err := os.Open(v.lfsObject(oid))
+	if err != nil {
+		return nil, &MissingLfsError{Path: path, Oid: oid}
+	}
+
+	// A partial download isn't the object.
+	if info, err := object.Stat(); err != nil || info.Size() != size {
+		object.Close()
+		return nil, &MissingLfsError{Path: path, Oid: oid}
+	}
+
+	return object, nil
+}
+
+// Files tracked by Git LFS whose objects aren't in the local
+// store. Only files that are uploaded from the commit and
+// match "filter=lfs" in .gitattributes are checked.
+func (v *Version) MissingLfs(commit string, files []File) ([]string, error) {
+	var missing []string
+
+	for _, file := range files {
+		if file.Worktree || file.IsSymlink() || file.Operation == DELETED || !v.isLfs(commit, file.Path()) {
+			continue
+		}
+
+		// Exact renames are done on the server.
+		if file.Operation == RENAMED && file.Similarity == 100 {
+			continue
+		}
+
+		blob, err := v.Blob(commit, file.Path())
+		if IsMissingLfs(err) {
+			missing = append(missing, file.Path())
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		blob.Close()
+	}
+
+	return missing, nil
+}
+
+// Check if a file is tracked by Git LFS, by "filter=lfs" in
+// the .gitattributes of its directory and the ones above.
+func (v *Version) isLfs(commit, path string) bool {
+	return v.lfsAttributes(commit, parentDir(path)).matcher.Match(path)
+}
+
+// Patterns of the files tracked by Git LFS in a directory of
+// a commit. Each .gitattributes adds its own patterns after
+// those of its parents, so deeper files override them.
+func (v *Version) lfsAttributes(commit, dir string) *lfsAttributes {
+	key := commit + ":" + dir
+
+	v.mutex.Lock()
+	cached, ok := v.lfs[key]
+	v.mutex.Unlock()
+
+	if ok {
+		return cached
+	}
+
+	var lines []string
+	name := ".gitattributes"
+
+	if dir != "" {
+		lines = append(lines, v.lfsAttributes(commit, parentDir(dir)).lines...)
+		name = dir + "/" + name
+	}
+
+	if blob, err := v.backend.Blob(commit, name); err == nil {
+		contents, err := ioutil.ReadAll(blob)
+		blob.Close()
+		if err == nil {
+			lines = append(lines, scopePatterns(ParseAttributes(string(contents), "filter=lfs"), dir)...)
+		}
+	}
+
+	attributes := &lfsAttributes{lines: lines, matcher: NewMatcher(lines)}
+
+	v.mutex.Lock()
+	v.lfs[key] = attributes
+	v.mutex.Unlock()
+
+	return attributes
+}
+
+// Make patterns of a .gitattributes in a directory relative
+// to the root. Patterns without a slash match at any level
+// below the directory, the others from it.
+func scopePatterns(lines []string, dir string) []string {
+	if dir == "" {
+		return lines
+	}
+
+	prefix := escapeGlob(dir) + "/"
+
+	var scoped []string
+	for _, line := range lines {
+		negate := ""
+		if strings.HasPrefix(line, "!") {
+			negate = "!"
+			line = line[1:]
+		}
+
+		if strings.Contains(strings.TrimRight(line, "/"), "/") {
+			scoped = append(scoped, negate+prefix+strings.TrimPrefix(line, "/"))
+		} else {
+			scoped = append(scoped, negate+prefix+"**/"+line)
+		}
+	}
+
+	return scoped
+}
+
+// Escape the characters of a path that patterns treat as
+// special.
+func escapeGlob(path string) string {
+	var escaped []byte
+	for i := 0; i < len(path); i++ {
+		if strings.IndexByte("*?[]\\!#", path[i]) >= 0 {
+			escaped = append(escaped, '\\')
+		}
+
+		escaped = append(escaped, path[i])
+	}
+
+	return string(escaped)
+}
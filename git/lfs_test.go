@@ -0,0 +1,93 @@
+package git
+
+import (
+	"testing"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// Pointer to an object and its id.
+func pointer(contents string) (string, string) {
+	sum := sha256.Sum256([]byte(contents))
+	oid := hex.EncodeToString(sum[:])
+
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, len(contents)), oid
+}
+
+func TestParsePointer(t *testing.T) {
+	contents, oid := pointer("image")
+
+	actual, size, ok := parsePointer([]byte(contents))
+	if !ok || actual != oid || size != 5 {
+		t.Fatalf("Expected a pointer to %s, but got %s", oid, actual)
+	}
+
+	for _, contents := range []string{"", "plain text", "version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 5\n"} {
+		if _, _, ok := parsePointer([]byte(contents)); ok {
+			t.Fatalf("Expected %q not to be a pointer.", contents)
+		}
+	}
+}
+
+func TestLfsObjects(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		stored, oid := pointer("stored image")
+		missing, _ := pointer("missing image")
+
+		write(t, ".gitattributes", "*.png filter=lfs diff=lfs merge=lfs -text\n")
+		write(t, "stored.png", stored)
+		write(t, "missing.png", missing)
+		write(t, "plain.txt", "plain")
+		write(t, "pointer.txt", stored)
+		write(t, "assets/.gitattributes", "*.bin filter=lfs\n/kept.png -filter\n")
+		write(t, "assets/data/nested.bin", missing)
+		write(t, "assets/kept.png", missing)
+		write(t, "other.bin", missing)
+		commit(t, "first")
+
+		write(t, filepath.Join(".git", "lfs", "objects", oid[0:2], oid[2:4], oid), "stored image")
+
+		vcs, _ := New("master", backend)
+		blob, err := vcs.Blob(vcs.RefHead(), "stored.png")
+		if err != nil {
+			t.Fatalf("Blob couldn't be read: %s", err.Error())
+		}
+
+		contents, _ := ioutil.ReadAll(blob)
+		blob.Close()
+		if string(contents) != "stored image" {
+			t.Fatalf("Expected the LFS object but got %q", contents)
+		}
+
+		if _, err = vcs.Blob(vcs.RefHead(), "missing.png"); !IsMissingLfs(err) {
+			t.Fatalf("Expected a missing LFS object, but got %v", err)
+		}
+
+		// Pointers of files that aren't tracked are left as they are.
+		blob, err = vcs.Blob(vcs.RefHead(), "pointer.txt")
+		if err != nil {
+			t.Fatalf("Blob couldn't be read: %s", err.Error())
+		}
+
+		contents, _ = ioutil.ReadAll(blob)
+		blob.Close()
+		if string(contents) != stored {
+			t.Fatalf("Expected the pointer but got %q", contents)
+		}
+
+		files := changes(t, vcs, "", "")
+		actual, err := vcs.MissingLfs(vcs.RefHead(), files)
+		expected := []string{"assets/data/nested.bin", "missing.png"}
+		sort.Strings(actual)
+		if err != nil || !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("Expected %v to be missing, but got %v", expected, actual)
+		}
+	})
+}
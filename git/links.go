@@ -0,0 +1,155 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Links followed to resolve a path before giving up, as in
+// Linux.
+const maxLinks = 40
+
+// Resolve a symlink to the path it points to in the commit,
+// following other links on the way. Targets outside the
+// repository or missing from the commit are an error.
+func (v *Version) ResolveLink(commit, path string) (string, error) {
+	files, err := v.tree(commit)
+	if err != nil {
+		return "", err
+	}
+
+	hops := 0
+	resolved, err := v.followLink(commit, files, path, &hops)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := files[resolved]; ok {
+		return resolved, nil
+	}
+
+	for name := range files {
+		if strings.HasPrefix(name, resolved+"/") {
+			return resolved, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s points to a file that doesn't exist in the commit.", path)
+}
+
+// Files of a commit at a path: the file itself, or those
+// inside it when it's a directory.
+func (v *Version) Files(commit, path string) ([]File, error) {
+	files, err := v.tree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	if file, ok := files[path]; ok {
+		return []File{file}, nil
+	}
+
+	var inside []File
+	for name, file := range files {
+		if strings.HasPrefix(name, path+"/") {
+			inside = append(inside, file)
+		}
+	}
+
+	sort.Slice(inside, func(i, j int) bool {
+		return inside[i].Name < inside[j].Name
+	})
+
+	return inside, nil
+}
+
+// Follow a link to what it points to, one part of the
+// target at a time, as parts may be links themselves.
+func (v *Version) followLink(commit string, files map[string]File, path string, hops *int) (string, error) {
+	if *hops++; *hops > maxLinks {
+		return "", fmt.Errorf("%s has too many levels of symlinks.", path)
+	}
+
+	blob, err := v.Blob(commit, path)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(string(target), "/") {
+		return "", fmt.Errorf("%s points outside the repository.", path)
+	}
+
+	current := parentDir(path)
+
+	for _, part := range strings.Split(string(target), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current == "" {
+				return "", fmt.Errorf("%s points outside the repository.", path)
+			}
+
+			current = parentDir(current)
+			continue
+		}
+
+		if current != "" {
+			current += "/"
+		}
+
+		current += part
+
+		if files[current].IsSymlink() {
+			if current, err = v.followLink(commit, files, current, hops); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return current, nil
+}
+
+// Files of a commit by path, listed once.
+func (v *Version) tree(commit string) (map[string]File, error) {
+	v.mutex.Lock()
+	cached, ok := v.trees[commit]
+	v.mutex.Unlock()
+
+	if ok {
+		return cached, nil
+	}
+
+	list, err := v.Changes("", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]File{}
+	for _, file := range list {
+		files[file.Name] = file
+	}
+
+	v.mutex.Lock()
+	v.trees[commit] = files
+	v.mutex.Unlock()
+
+	return files, nil
+}
+
+// Directory of a path in the repository, empty for the root.
+func parentDir(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+
+	return ""
+}
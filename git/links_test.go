@@ -0,0 +1,64 @@
+package git
+
+import (
+	"os"
+	"testing"
+)
+
+func TestResolveLink(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend string) {
+		defer fixtureRepo(t)()
+
+		write(t, "docs/guide.md", "guide")
+		write(t, "docs/intro.md", "intro")
+
+		links := map[string]string{
+			"link":     "docs",
+			"alias":    "link/guide.md",
+			"sub/up":   "../docs/intro.md",
+			"outside":  "../secret",
+			"absolute": "/etc/passwd",
+			"missing":  "docs/missing.md",
+			"loop":     "loop",
+		}
+
+		for link, target := range links {
+			os.MkdirAll("sub", 0755)
+			if err := os.Symlink(target, link); err != nil {
+				t.Skip("Symlinks can't be created.")
+			}
+		}
+
+		commit(t, "first")
+
+		// The working tree doesn't matter, only the commit.
+		os.Remove("link")
+		os.Symlink("sub", "link")
+
+		vcs, _ := New("master", backend)
+		head := vcs.RefHead()
+
+		resolved := map[string]string{
+			"link":   "docs",
+			"alias":  "docs/guide.md",
+			"sub/up": "docs/intro.md",
+		}
+
+		for link, expected := range resolved {
+			if actual, err := vcs.ResolveLink(head, link); err != nil || actual != expected {
+				t.Fatalf("Expected %s to resolve to %s, but got %q (%v)", link, expected, actual, err)
+			}
+		}
+
+		for _, link := range []string{"outside", "absolute", "missing", "loop"} {
+			if _, err := vcs.ResolveLink(head, link); err == nil {
+				t.Fatalf("Expected %s not to resolve.", link)
+			}
+		}
+
+		files, err := vcs.Files(head, "docs")
+		if err != nil || len(files) != 2 || files[0].Name != "docs/guide.md" || files[1].Name != "docs/intro.md" {
+			t.Fatalf("Expected the files of docs, but got %+v (%v)", files, err)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Replace submodules in a list of changes with their files.
+// A new submodule lists all of its files, while one whose
+// commit changed is diffed between the old and new commits.
+func (v *Version) expandModules(files []File, remote, local string) ([]File, error) {
+	to, err := v.modulesAt(local)
+	if err != nil {
+		return nil, err
+	}
+
+	from := map[string]string{}
+	if remote != "" {
+		if from, err = v.modulesAt(remote); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(from) == 0 && len(to) == 0 {
+		return files, nil
+	}
+
+	var out []File
+	for _, file := range files {
+		changes := []File{file}
+
+		// A rename that involves a submodule is a deletion of
+		// the old path and an addition of the new one.
+		if file.Operation == RENAMED && (from[file.OldName] != "" || to[file.Name] != "") {
+			changes = []File{
+				{Name: file.OldName, Operation: DELETED},
+				{Name: file.Name, Operation: ADDED, Mode: file.Mode},
+			}
+		}
+
+		for _, change := range changes {
+			expanded, err := v.expandModule(change, from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, expanded...)
+		}
+	}
+
+	return out, nil
+}
+
+// Expand a single change, if it's a submodule.
+func (v *Version) expandModule(file File, from, to map[string]string) ([]File, error) {
+	oldhash, newhash := from[file.Name], to[file.Name]
+
+	switch file.Operation {
+	case ADDED:
+		oldhash = ""
+	case DELETED:
+		newhash = ""
+	}
+
+	if oldhash == "" && newhash == "" {
+		return []File{file}, nil
+	}
+
+	if v.exclude[file.Name] {
+		return nil, nil
+	}
+
+	if oldhash != "" && newhash != "" {
+		return v.moduleChanges(file.Name, oldhash, newhash)
+	}
+
+	var out []File
+
+	// A submodule that's gone may not be checked out
+	// anymore. Its files are deleted when they can be listed.
+	if oldhash != "" {
+		if files, err := v.moduleChanges(file.Name, "", oldhash); err == nil {
+			for _, f := range files {
+				out = append(out, File{Name: f.Name, Operation: DELETED})
+			}
+		}
+
+		// Replaced by a file.
+		if file.Operation != DELETED {
+			out = append(out, File{Name: file.Name, Operation: ADDED, Mode: file.Mode})
+		}
+	}
+
+	if newhash != "" {
+		// Replaced a file.
+		if file.Operation != ADDED {
+			out = append(out, File{Name: file.Name, Operation: DELETED})
+		}
+
+		files, err := v.moduleChanges(file.Name, "", newhash)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, files...)
+	}
+
+	return out, nil
+}
+
+// Changes in a submodule between two of its commits, named
+// relative to the parent repository.
+func (v *Version) moduleChanges(path, from, to string) ([]File, error) {
+	sub, err := v.module(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sub.backend.Resolve(to); err != nil {
+		return nil, fmt.Errorf("Submodule %s doesn't have commit %s. Run 'git submodule update' to fetch it.", path, to)
+	}
+
+	files, err := sub.Changes(from, to)
+
+	// History that isn't there, as in shallow submodules, is
+	// deployed as a whole.
+	if IsUnknownRevision(err) {
+		files, err = sub.Changes("", to)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		files[i].Name = path + "/" + files[i].Name
+		if files[i].OldName != "" {
+			files[i].OldName = path + "/" + files[i].OldName
+		}
+	}
+
+	return files, nil
+}
+
+// Submodules in a commit, by path.
+func (v *Version) modulesAt(commit string) (map[string]string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if modules, ok := v.modules[commit]; ok {
+		return modules, nil
+	}
+
+	modules, err := v.backend.Modules(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	v.modules[commit] = modules
+
+	return modules, nil
+}
+
+// Open the repository of a submodule. It has to be checked
+// out, as its history isn't in the parent repository.
+func (v *Version) module(path string) (*Version, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if sub, ok := v.subs[path]; ok {
+		return sub, nil
+	}
+
+	dir := filepath.Join(v.dir, filepath.FromSlash(path))
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return nil, fmt.Errorf("Submodule %s isn't checked out. Run 'git submodule update --init' or exclude it with 'excludemodules'.", path)
+	}
+
+	sub, err := open(dir, v.kind)
+	if err != nil {
+		return nil, err
+	}
+
+	// Excluded submodules may be nested in this one.
+	for excluded := range v.exclude {
+		if strings.HasPrefix(excluded, path+"/") {
+			sub.exclude[excluded[len(path)+1:]] = true
+		}
+	}
+
+	v.subs[path] = sub
+
+	return sub, nil
+}
@@ -0,0 +1,647 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+)
+
+// Backend that reads the repository with go-git, for
+// systems without git.
+type native struct {
+	gitdir    string
+	commondir string
+	repo      *gogit.Repository
+}
+
+// A file that was added or deleted, kept to find renames.
+type change struct {
+	file  File
+	hash  plumbing.Hash
+	index int
+}
+
+// Renames are looked for between at most this many deleted
+// and added files, like git's own limit.
+const renameLimit = 1000
+
+// Blobs larger than this are only renamed when identical, so
+// they're never read whole to be compared.
+const renameMaxSize = 4 << 20
+
+// Empty files all share this hash, so they aren't renames.
+const emptyBlob = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+// Initialise the backend by finding the repository from a
+// directory upwards.
+func newNative(dir string) (*native, error) {
+	gitdir, err := findGitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &native{gitdir: gitdir, commondir: gitdir}
+
+	// Worktrees keep refs and objects in the main repository.
+	if contents, err := ioutil.ReadFile(filepath.Join(gitdir, "commondir")); err == nil {
+		dir := strings.TrimSpace(string(contents))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(gitdir, dir)
+		}
+
+		n.commondir = dir
+	}
+
+	fs := dotgit.NewRepositoryFilesystem(osfs.New(n.gitdir), osfs.New(n.commondir))
+	storage := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	// The working tree isn't needed, as files are only ever
+	// read from commits.
+	if n.repo, err = gogit.Open(storage, nil); err != nil {
+		return nil, fmt.Errorf("%s couldn't be opened. %s.", gitdir, err.Error())
+	}
+
+	return n, nil
+}
+
+// Find the .git directory. It may be a file pointing to the
+// actual directory, as with worktrees and submodules.
+func findGitDir(dir string) (string, error) {
+	if env := os.Getenv("GIT_DIR"); env != "" && dir == "." {
+		return filepath.Abs(env)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		candidate := filepath.Join(dir, ".git")
+		info, err := os.Stat(candidate)
+
+		if err == nil && info.IsDir() {
+			return candidate, nil
+		}
+
+		if err == nil {
+			contents, err := ioutil.ReadFile(candidate)
+			if err != nil || !bytes.HasPrefix(contents, []byte("gitdir:")) {
+				return "", fmt.Errorf("%s isn't a valid git file.", candidate)
+			}
+
+			gitdir := strings.TrimSpace(string(contents[len("gitdir:"):]))
+			if !filepath.IsAbs(gitdir) {
+				gitdir = filepath.Join(dir, gitdir)
+			}
+
+			return gitdir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("Current directory isn't a git repository.")
+		}
+
+		dir = parent
+	}
+}
+
+// Directory shared by the worktrees of the repository.
+func (n *native) CommonDir() string {
+	return n.commondir
+}
+
+// Resolve a revision to a commit hash. It understands refs,
+// full and abbreviated hashes, and the ~ and ^ suffixes.
+func (n *native) Resolve(rev string) (string, error) {
+	hash, err := n.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", fmt.Errorf("Revision '%s' doesn't exist.", rev)
+	}
+
+	// Hashes are resolved without checking what they point to.
+	if _, err = n.repo.CommitObject(*hash); err != nil {
+		return "", fmt.Errorf("Revision '%s' doesn't exist.", rev)
+	}
+
+	return hash.String(), nil
+}
+
+// List the files of a commit, including those in
+// subdirectories.
+func (n *native) List(commit string) ([]File, error) {
+	tree, err := n.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []File
+	err = n.walkTree(tree, "", func(name string, entry object.TreeEntry) {
+		list = append(list, File{
+			Name:      name,
+			Operation: ADDED,
+			Mode:      modeString(entry.Mode),
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// List the submodules of a commit, which are entries for
+// commits instead of blobs.
+func (n *native) Modules(commit string) (map[string]string, error) {
+	tree, err := n.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := map[string]string{}
+	err = n.walkTree(tree, "", func(name string, entry object.TreeEntry) {
+		if entry.Mode == filemode.Submodule {
+			modules[name] = entry.Hash.String()
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+// List the files that changed between two commits, finding
+// renames as git diff -M does.
+func (n *native) Diff(from, to string) ([]File, error) {
+	fromTree, err := n.commitTree(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := n.commitTree(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []File
+	var deleted, added []change
+
+	// Additions and deletions are kept aside, as some of them
+	// may turn out to be renames.
+	err = n.diffTrees(fromTree, toTree, "", func(file File, hash plumbing.Hash) {
+		switch file.Operation {
+		case DELETED:
+			deleted = append(deleted, change{file: file, hash: hash, index: len(list)})
+		case ADDED:
+			added = append(added, change{file: file, hash: hash, index: len(list)})
+		}
+
+		list = append(list, file)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deleted) > 0 && len(added) > 0 {
+		n.findRenames(list, deleted, added)
+	}
+
+	// Renames leave their deletion empty.
+	var out []File
+	for _, file := range list {
+		if file.Name != "" {
+			out = append(out, file)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+// Read a file as it is in a commit. Contents are streamed
+// from the object store, so large files aren't held in
+// memory.
+func (n *native) Blob(commit, path string) (io.ReadCloser, error) {
+	tree, err := n.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := n.findEntry(tree, path)
+	if err != nil || entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule {
+		return nil, fmt.Errorf("%s isn't in commit %s.", path, commit)
+	}
+
+	blob, err := n.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("%s couldn't be read from commit %s. %s.", path, commit, err.Error())
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("%s couldn't be read from commit %s. %s.", path, commit, err.Error())
+	}
+
+	return reader, nil
+}
+
+// Fetch history from origin. Shallow clones get all of it,
+// like git fetch --unshallow.
+func (n *native) Fetch(rev string) error {
+	depth := 0
+	if shallows, err := n.repo.Storer.Shallow(); err == nil && len(shallows) > 0 {
+		depth = math.MaxInt32
+	}
+
+	err := n.repo.Fetch(&gogit.FetchOptions{RemoteName: "origin", Depth: depth, Tags: gogit.AllTags})
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return fmt.Errorf("History couldn't be fetched from origin. %s.", err.Error())
+	}
+
+	if _, err = n.Resolve(rev); err == nil || len(rev) != 40 || !isHex(rev) {
+		return nil
+	}
+
+	// A commit no branch has anymore, as after a force push,
+	// can still be fetched by hash from servers that allow it.
+	// The ref it needs is removed after, like git's FETCH_HEAD
+	// it doesn't keep the commit around.
+	ref := plumbing.ReferenceName("refs/steer/fetch")
+	spec := config.RefSpec(rev + ":" + ref.String())
+
+	err = n.repo.Fetch(&gogit.FetchOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{spec}, Depth: depth})
+	n.repo.Storer.RemoveReference(ref)
+
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return fmt.Errorf("Revision %s couldn't be fetched from origin. %s.", rev, err.Error())
+	}
+
+	return nil
+}
+
+// Get the root tree of a commit.
+func (n *native) commitTree(rev string) (*object.Tree, error) {
+	hash, err := n.Resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := n.repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("Commit %s couldn't be read. %s.", hash, err.Error())
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("Tree of commit %s couldn't be read. %s.", hash, err.Error())
+	}
+
+	return tree, nil
+}
+
+// Find the entry of a path. go-git's own lookup refuses names
+// git allows, like those with newlines.
+func (n *native) findEntry(tree *object.Tree, path string) (object.TreeEntry, error) {
+	parts := strings.Split(path, "/")
+
+	for i, part := range parts {
+		var entry *object.TreeEntry
+		for j := range tree.Entries {
+			if tree.Entries[j].Name == part {
+				entry = &tree.Entries[j]
+				break
+			}
+		}
+
+		if entry == nil {
+			return object.TreeEntry{}, fmt.Errorf("%s doesn't exist.", path)
+		}
+
+		if i == len(parts)-1 {
+			return *entry, nil
+		}
+
+		if entry.Mode != filemode.Dir {
+			return object.TreeEntry{}, fmt.Errorf("%s doesn't exist.", path)
+		}
+
+		var err error
+		if tree, err = n.repo.TreeObject(entry.Hash); err != nil {
+			return object.TreeEntry{}, err
+		}
+	}
+
+	return object.TreeEntry{}, fmt.Errorf("%s doesn't exist.", path)
+}
+
+// Call fn for every entry that isn't a directory, going
+// into subdirectories.
+func (n *native) walkTree(tree *object.Tree, prefix string, fn func(string, object.TreeEntry)) error {
+	for _, entry := range tree.Entries {
+		if entry.Mode != filemode.Dir {
+			fn(prefix+entry.Name, entry)
+			continue
+		}
+
+		sub, err := n.repo.TreeObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err = n.walkTree(sub, prefix+entry.Name+"/", fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compare two trees. Subdirectories with the same hash have
+// the same contents, so they're skipped. go-git's own diff
+// refuses names git allows, like those with newlines.
+func (n *native) diffTrees(from, to *object.Tree, prefix string, fn func(File, plumbing.Hash)) error {
+	fromEntries := map[string]object.TreeEntry{}
+	toEntries := map[string]object.TreeEntry{}
+	var names []string
+
+	if from != nil {
+		for _, entry := range from.Entries {
+			fromEntries[entry.Name] = entry
+			names = append(names, entry.Name)
+		}
+	}
+
+	if to != nil {
+		for _, entry := range to.Entries {
+			toEntries[entry.Name] = entry
+			if _, ok := fromEntries[entry.Name]; !ok {
+				names = append(names, entry.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		old, inFrom := fromEntries[name]
+		cur, inTo := toEntries[name]
+		path := prefix + name
+
+		if inFrom && inTo && old.Hash == cur.Hash && old.Mode == cur.Mode {
+			continue
+		}
+
+		oldTree := inFrom && old.Mode == filemode.Dir
+		curTree := inTo && cur.Mode == filemode.Dir
+
+		// A directory on either side is compared as a whole,
+		// while a file on the other side is deleted or added.
+		if oldTree || curTree {
+			var fromSub, toSub *object.Tree
+			var err error
+
+			if oldTree {
+				if fromSub, err = n.repo.TreeObject(old.Hash); err != nil {
+					return err
+				}
+			}
+
+			if curTree {
+				if toSub, err = n.repo.TreeObject(cur.Hash); err != nil {
+					return err
+				}
+			}
+
+			if inFrom && !oldTree {
+				fn(File{Name: path, Operation: DELETED, Mode: modeString(old.Mode)}, old.Hash)
+			}
+
+			if err = n.diffTrees(fromSub, toSub, path+"/", fn); err != nil {
+				return err
+			}
+
+			if inTo && !curTree {
+				fn(File{Name: path, Operation: ADDED, Mode: modeString(cur.Mode)}, cur.Hash)
+			}
+
+			continue
+		}
+
+		switch {
+		case !inTo:
+			fn(File{Name: path, Operation: DELETED, Mode: modeString(old.Mode)}, old.Hash)
+		case !inFrom:
+			fn(File{Name: path, Operation: ADDED, Mode: modeString(cur.Mode)}, cur.Hash)
+		case kind(old.Mode) != kind(cur.Mode):
+			fn(File{Name: path, Operation: TYPE, Mode: modeString(cur.Mode)}, cur.Hash)
+		default:
+			fn(File{Name: path, Operation: MODIFIED, Mode: modeString(cur.Mode)}, cur.Hash)
+		}
+	}
+
+	return nil
+}
+
+// Pair deleted and added files into renames. Identical files
+// are matched first, then those similar enough.
+func (n *native) findRenames(list []File, deleted, added []change) {
+	used := map[int]bool{}
+	rename := func(from, to change, similarity int) {
+		list[to.index] = File{
+			Name:       to.file.Name,
+			Operation:  RENAMED,
+			OldName:    from.file.Name,
+			Similarity: similarity,
+			Mode:       to.file.Mode,
+		}
+
+		list[from.index] = File{}
+		used[from.index] = true
+	}
+
+	var remaining []change
+	for _, a := range added {
+		matched := false
+		for _, d := range deleted {
+			if !used[d.index] && d.hash == a.hash && a.hash.String() != emptyBlob {
+				rename(d, a, 100)
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			remaining = append(remaining, a)
+		}
+	}
+
+	if len(deleted)*len(remaining) > renameLimit*renameLimit {
+		return
+	}
+
+	// Only the lines of deleted files are kept, while each
+	// added file is read once and dropped after.
+	lines := map[int]map[uint64]int{}
+	for _, d := range deleted {
+		if !used[d.index] {
+			lines[d.index] = n.blobLines(d.hash)
+		}
+	}
+
+	for _, a := range remaining {
+		added := n.blobLines(a.hash)
+		if added == nil {
+			continue
+		}
+
+		best, score := change{}, 0
+
+		for _, d := range deleted {
+			if used[d.index] {
+				continue
+			}
+
+			if s := similarity(lines[d.index], added); s > score {
+				best, score = d, s
+			}
+		}
+
+		// Same threshold as git's default.
+		if score >= 50 {
+			rename(best, a, score)
+		}
+	}
+}
+
+// Lines of a blob, hashed, with the number of bytes each has
+// in the file. Like git, binary files and large ones are only
+// renamed when identical, so they have no lines. Neither do
+// submodules and unreadable blobs.
+func (n *native) blobLines(hash plumbing.Hash) map[uint64]int {
+	blob, err := n.repo.BlobObject(hash)
+	if err != nil || blob.Size == 0 || blob.Size > renameMaxSize {
+		return nil
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil
+	}
+
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil || isBinary(data) {
+		return nil
+	}
+
+	lines := map[uint64]int{}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		h := fnv.New64a()
+		h.Write(line)
+		lines[h.Sum64()] += len(line)
+	}
+
+	return lines
+}
+
+// Files with a NUL byte near the start are binary, as git
+// decides it.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// How similar two files are, from 0 to 100, by the bytes of
+// the lines they have in common.
+func similarity(from, to map[uint64]int) int {
+	size := func(lines map[uint64]int) int {
+		total := 0
+		for _, n := range lines {
+			total += n
+		}
+
+		return total
+	}
+
+	max := size(from)
+	if s := size(to); s > max {
+		max = s
+	}
+
+	if max == 0 {
+		return 0
+	}
+
+	common := 0
+	for line, n := range to {
+		if m := from[line]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+
+	return common * 100 / max
+}
+
+// Mode as git prints it, like 100644 or 40000.
+func modeString(mode filemode.FileMode) string {
+	return strconv.FormatUint(uint64(mode), 8)
+}
+
+// Files, symlinks and submodules are different kinds, while
+// a change of permissions is still a file.
+func kind(mode filemode.FileMode) string {
+	switch mode {
+	case filemode.Symlink:
+		return "link"
+	case filemode.Submodule:
+		return "module"
+	}
+
+	return "file"
+}
+
+// Check if a string is hexadecimal.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,163 @@
+package git
+
+import (
+	"testing"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// Output of a git command in the current repository.
+func output(t *testing.T, args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		t.Fatalf("git %s failed.", strings.Join(args, " "))
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestNativePackedRepository(t *testing.T) {
+	defer fixtureRepo(t)()
+
+	// Small edits to a large file are stored as deltas.
+	contents := strings.Repeat("a line that repeats\n", 500)
+	write(t, "large.txt", contents)
+	write(t, "dir/kept.txt", "kept")
+	commit(t, "first")
+
+	for i := 0; i < 3; i++ {
+		contents += "more\n"
+		write(t, "large.txt", contents)
+		commit(t, "edit")
+	}
+
+	run(t, "tag", "-a", "v1", "-m", "release")
+	run(t, "gc", "-q", "--aggressive")
+
+	vcs, err := New("master", "native")
+	if err != nil {
+		t.Fatalf("Version couldn't be initialised: %s", err.Error())
+	}
+
+	if vcs.RefHead() != output(t, "rev-parse", "master") {
+		t.Fatalf("Expected the packed ref of master, but got %s", vcs.RefHead())
+	}
+
+	blob, err := vcs.Blob(vcs.RefHead(), "large.txt")
+	if err != nil {
+		t.Fatalf("Blob couldn't be read: %s", err.Error())
+	}
+
+	actual, _ := ioutil.ReadAll(blob)
+	blob.Close()
+	if string(actual) != contents {
+		t.Fatalf("Blob read from a delta doesn't match.")
+	}
+
+	files := changes(t, vcs, output(t, "rev-parse", "master~3"), "")
+	if len(files) != 1 || files[0].Name != "large.txt" || files[0].Operation != MODIFIED {
+		t.Fatalf("Expected large.txt to be modified, but got %+v", files)
+	}
+}
+
+func TestNativeResolve(t *testing.T) {
+	defer fixtureRepo(t)()
+
+	write(t, "file.txt", "one")
+	commit(t, "first")
+	write(t, "file.txt", "two")
+	commit(t, "second")
+	run(t, "tag", "-a", "v1", "-m", "release")
+
+	n, err := newNative(".")
+	if err != nil {
+		t.Fatalf("Backend couldn't be initialised: %s", err.Error())
+	}
+
+	head := output(t, "rev-parse", "HEAD")
+	first := output(t, "rev-parse", "HEAD~1")
+
+	revs := map[string]string{
+		"HEAD":         head,
+		"master":       head,
+		"v1":           head,
+		"refs/tags/v1": head,
+		head[:7]:       head,
+		"master~1":     first,
+		"HEAD^":        first,
+		"v1~1":         first,
+		first:          first,
+	}
+
+	for rev, expected := range revs {
+		actual, err := n.Resolve(rev)
+		if err != nil || actual != expected {
+			t.Fatalf("Expected %s to be %s, but got %s", rev, expected, actual)
+		}
+	}
+
+	for _, rev := range []string{"missing", "master~5", "0000000"} {
+		if _, err := n.Resolve(rev); err == nil {
+			t.Fatalf("Resolving %s should fail.", rev)
+		}
+	}
+}
+
+func TestNativeTypeChange(t *testing.T) {
+	defer fixtureRepo(t)()
+
+	write(t, "link", "file")
+	write(t, "dir/file.txt", "file")
+	commit(t, "first")
+	first := output(t, "rev-parse", "HEAD")
+
+	run(t, "rm", "-q", "link", "dir/file.txt")
+	write(t, "dir", "now a file")
+	if err := exec.Command("ln", "-s", "dir", "link").Run(); err != nil {
+		t.Skip("Symlinks can't be created.")
+	}
+
+	commit(t, "second")
+
+	cli, _ := New("master", "cli")
+	native, _ := New("master", "native")
+
+	expected := changes(t, cli, first, "")
+	actual := changes(t, native, first, "")
+
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected %+v but got %+v", expected, actual)
+	}
+
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("Expected %+v but got %+v", expected[i], actual[i])
+		}
+	}
+}
+
+// Binary and large files are only renamed when they're
+// identical, as they aren't read to be compared.
+func TestNativeRenameSkipsBinaryAndLarge(t *testing.T) {
+	defer fixtureRepo(t)()
+
+	large := strings.Repeat("line\n", renameMaxSize/5+1)
+	write(t, "image.bin", "\x00"+strings.Repeat("data\n", 50))
+	write(t, "large.txt", large)
+	commit(t, "first")
+	first := output(t, "rev-parse", "HEAD")
+
+	run(t, "rm", "-q", "image.bin", "large.txt")
+	write(t, "moved.bin", "\x00"+strings.Repeat("data\n", 50)+"edit\n")
+	write(t, "moved.txt", large+"edit\n")
+	commit(t, "second")
+
+	native, _ := New("master", "native")
+
+	for _, file := range changes(t, native, first, "") {
+		if file.Operation == RENAMED {
+			t.Fatalf("Expected no renames, but got %+v", file)
+		}
+	}
+}
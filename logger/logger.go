@@ -3,7 +3,6 @@ package logger
 import (
 	"time"
 	"fmt"
-	"os"
 	"strings"
 	"github.com/fadion/steer/server"
 )
@@ -49,22 +48,7 @@ func (l *Log) Write(nrfiles int, branch, commit, message string) (string, error)
 		remote += "\n" + contents
 	}
 
-	f, err := os.Create(l.file)
-	if err != nil {
-		return "", err
-	}
-
-	_, err = f.WriteString(remote)
-	if err != nil {
-		return "", err
-	}
-
-	defer f.Close()
-	defer os.Remove(l.file)
-
-	f.Sync()
-
-	err = l.conn.Upload(l.file, l.file)
+	err = l.conn.Put(strings.NewReader(remote), l.file)
 	if err != nil {
 		return "", err
 	}
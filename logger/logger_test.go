@@ -2,6 +2,10 @@ package logger
 
 import (
 	"testing"
+	"os"
+	"io"
+	"io/ioutil"
+	"strings"
 	"github.com/fadion/steer/server"
 	"time"
 	"fmt"
@@ -12,12 +16,20 @@ var logcontents = "2017-06-27 05:00:00 +0200 | Commit: abc | Branch: master | Ch
 
 type MockServerDriver struct{}
 
-func (d *MockServerDriver) MkDir(path string) error               { return nil }
-func (d *MockServerDriver) Upload(path, destination string) error { return nil }
-func (d *MockServerDriver) Read(path string) (string, error)      { return logcontents, nil }
-func (d *MockServerDriver) Delete(path string) error              { return nil }
-func (d *MockServerDriver) Exec(command string) (string, error)   { return "", nil }
-func (d *MockServerDriver) Close()                                {}
+func (d *MockServerDriver) MkDir(path string) error                   { return nil }
+func (d *MockServerDriver) Put(r io.Reader, destination string) error { return nil }
+func (d *MockServerDriver) Get(path string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(logcontents)), nil
+}
+func (d *MockServerDriver) Delete(path string) error                  { return nil }
+func (d *MockServerDriver) RemoveDir(path string) error               { return nil }
+func (d *MockServerDriver) List(path string) ([]os.FileInfo, error)   { return nil, nil }
+func (d *MockServerDriver) Stat(path string) (os.FileInfo, error)     { return nil, os.ErrNotExist }
+func (d *MockServerDriver) Rename(from, to string) error              { return nil }
+func (d *MockServerDriver) Symlink(target, link string) error         { return nil }
+func (d *MockServerDriver) Chmod(path string, mode os.FileMode) error { return nil }
+func (d *MockServerDriver) Exec(command string) (string, error)       { return "", nil }
+func (d *MockServerDriver) Close()                                    {}
 
 func TestLogRead(t *testing.T) {
 	log := New(connection)
@@ -58,4 +70,4 @@ func TestLogClear(t *testing.T) {
 	if !log.Clear() {
 		t.Fatalf("Log clear didn't work.")
 	}
-}
\ No newline at end of file
+}
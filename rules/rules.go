@@ -0,0 +1,25 @@
+package rules
+
+import (
+	"path"
+	"strings"
+)
+
+// A value that applies to the files matching a pattern.
+type Rule struct {
+	Pattern string
+	Value   string
+}
+
+// Check if a file matches a pattern. Patterns without a
+// slash match the file name, the others the whole path.
+func Match(pattern, name string) bool {
+	subject := name
+	if !strings.Contains(pattern, "/") {
+		subject = path.Base(name)
+	}
+
+	ok, _ := path.Match(pattern, subject)
+
+	return ok
+}
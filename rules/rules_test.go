@@ -0,0 +1,27 @@
+package rules
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"*.html", "index.html", true},
+		{"*.html", "blog/post.html", true},
+		{"assets/*", "assets/app.js", true},
+		{"assets/*", "assets/js/app.js", false},
+		{"assets/*", "public/assets/app.js", false},
+		{"config/local.php", "config/local.php", true},
+		{"*.css", "app.js", false},
+	}
+
+	for _, c := range cases {
+		if actual := Match(c.pattern, c.name); actual != c.matches {
+			t.Errorf("Expected %s to match %s: %v", c.pattern, c.name, c.matches)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSH authentication methods, tried in order: agent and private
+// key, password and finally keyboard-interactive. It keeps
+// track of what was tried, so failures can be reported.
+type sshAuth struct {
+	methods []ssh.AuthMethod
+	sources []string
+	tried   []string
+	skipped []string
+	agent   net.Conn
+}
+
+// Prepare the authentication methods from the parameters.
+func newSshAuth(cfg Params) *sshAuth {
+	a := &sshAuth{}
+	var signers []ssh.Signer
+	var keyring agent.Agent
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			a.skipped = append(a.skipped, fmt.Sprintf("agent (%s couldn't be reached)", sock))
+		} else {
+			a.agent = conn
+			keyring = agent.NewClient(conn)
+			a.sources = append(a.sources, "agent")
+		}
+	}
+
+	if cfg.Privatekey != "" {
+		signer, err := parsePrivateKey(cfg.Privatekey, cfg.Passphrase)
+		if err != nil {
+			a.skipped = append(a.skipped, fmt.Sprintf("private key (%s)", strings.TrimRight(err.Error(), ".")))
+		} else {
+			signers = append(signers, signer)
+			a.sources = append(a.sources, "private key")
+		}
+	}
+
+	// Agent keys and the private key are offered together, as
+	// the server only accepts one round of public keys.
+	if len(a.sources) > 0 {
+		a.methods = append(a.methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			a.attempt(fmt.Sprintf("public key (%s)", strings.Join(a.sources, ", ")))
+
+			if keyring == nil {
+				return signers, nil
+			}
+
+			agentsigners, err := keyring.Signers()
+			if err != nil {
+				return signers, nil
+			}
+
+			return append(agentsigners, signers...), nil
+		}))
+	}
+
+	if cfg.Password != "" {
+		a.methods = append(a.methods, ssh.PasswordCallback(func() (string, error) {
+			a.attempt("password")
+			return cfg.Password, nil
+		}))
+
+		// Servers that only allow keyboard-interactive usually
+		// just ask for the password.
+		a.methods = append(a.methods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			a.attempt("keyboard-interactive")
+
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = cfg.Password
+			}
+
+			return answers, nil
+		}))
+	}
+
+	return a
+}
+
+// Record an attempted method.
+func (a *sshAuth) attempt(method string) {
+	for _, t := range a.tried {
+		if t == method {
+			return
+		}
+	}
+
+	a.tried = append(a.tried, method)
+}
+
+// Describe why authentication failed.
+func (a *sshAuth) report(cfg Params) error {
+	msg := fmt.Sprintf("Couldn't authenticate on %s as '%s'.", cfg.Host, cfg.Username)
+
+	if len(a.methods) == 0 {
+		msg += " No authentication method is available. Set a password, a private key or run an SSH agent."
+	} else if len(a.tried) == 0 {
+		msg += " The server didn't accept any of the available methods."
+	} else {
+		msg += fmt.Sprintf(" Rejected: %s.", strings.Join(a.tried, ", "))
+	}
+
+	if len(a.skipped) > 0 {
+		msg += fmt.Sprintf(" Skipped: %s.", strings.Join(a.skipped, ", "))
+	}
+
+	return fmt.Errorf("%s\n", msg)
+}
+
+// Release the agent connection.
+func (a *sshAuth) close() {
+	if a.agent != nil {
+		a.agent.Close()
+	}
+}
+
+// Check if an SSH agent is running and holds any keys. An
+// empty agent can't authenticate, so it doesn't count.
+func AgentAvailable() bool {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return false
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+
+	return err == nil && len(keys) > 0
+}
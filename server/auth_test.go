@@ -0,0 +1,69 @@
+package server
+
+import (
+	"testing"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Serve a keyring on a temporary socket.
+func serveAgent(t *testing.T, keyring agent.Agent) func() {
+	dir, err := ioutil.TempDir("", "steer-agent")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Agent socket couldn't be opened: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	previous := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sock)
+
+	return func() {
+		os.Setenv("SSH_AUTH_SOCK", previous)
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAgentAvailable(t *testing.T) {
+	keyring := agent.NewKeyring()
+	defer serveAgent(t, keyring)()
+
+	if AgentAvailable() {
+		t.Fatalf("Expected an empty agent not to be available.")
+	}
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("Key couldn't be added to the agent: %s", err.Error())
+	}
+
+	if !AgentAvailable() {
+		t.Fatalf("Expected an agent with keys to be available.")
+	}
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	if AgentAvailable() {
+		t.Fatalf("Expected no agent without SSH_AUTH_SOCK.")
+	}
+}
@@ -1,11 +1,56 @@
 package server
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
 // Server connection driver.
 type Driver interface {
 	MkDir(path string) error
-	Upload(path, destination string) error
-	Read(path string) (string, error)
+	Put(r io.Reader, destination string) error
+	Get(path string) (io.ReadCloser, error)
 	Delete(path string) error
+	RemoveDir(path string) error
+	List(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	Rename(from, to string) error
+	Symlink(target, link string) error
+	Chmod(path string, mode os.FileMode) error
 	Exec(command string) (string, error)
 	Close()
 }
+
+// Error returned when a protocol can't do an operation.
+type UnsupportedError struct {
+	Scheme    string
+	Operation string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s doesn't support %s.", e.Scheme, e.Operation)
+}
+
+// Check if an error means the operation isn't supported.
+func IsUnsupported(err error) bool {
+	_, ok := err.(*UnsupportedError)
+	return ok
+}
+
+// File details for protocols that don't return an
+// os.FileInfo of their own.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modtime time.Time
+}
+
+func (f *fileInfo) Name() string       { return f.name }
+func (f *fileInfo) Size() int64        { return f.size }
+func (f *fileInfo) Mode() os.FileMode  { return f.mode }
+func (f *fileInfo) ModTime() time.Time { return f.modtime }
+func (f *fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f *fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"io/ioutil"
+	"path/filepath"
+)
+
+type filesystem struct {
+	basepath string
+}
+
+// Connect to a local or mounted directory.
+func ConnectFile(cfg Params) (*filesystem, error) {
+	// Deploying over the root of the filesystem is never
+	// what anyone wants, but it's the default path.
+	if filepath.Clean(cfg.Path) == string(os.PathSeparator) {
+		return nil, fmt.Errorf("Set a path to deploy to. The file scheme won't deploy on the root directory.\n")
+	}
+
+	info, err := os.Stat(cfg.Path)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("Path %s doesn't exist or isn't a directory.\n", cfg.Path)
+	}
+
+	return &filesystem{
+		basepath: cfg.Path,
+	}, nil
+}
+
+// Create directory.
+func (f *filesystem) MkDir(path string) error {
+	if err := os.MkdirAll(f.makePath(path), 0755); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Write the contents of a reader to a file.
+func (f *filesystem) Put(r io.Reader, destination string) error {
+	if err := f.MkDir(filepath.Dir(destination)); err != nil {
+		return err
+	}
+
+	dest, err := os.Create(f.makePath(destination))
+	if err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	// Write errors may only show when the file is synced or
+	// closed. A partial file is removed once it's closed.
+	_, err = io.Copy(dest, r)
+	if err == nil {
+		err = dest.Sync()
+	}
+
+	if cerr := dest.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		f.Delete(destination)
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	return nil
+}
+
+// Open a file for reading.
+func (f *filesystem) Get(path string) (io.ReadCloser, error) {
+	file, err := os.Open(f.makePath(path))
+	if err != nil {
+		return nil, fmt.Errorf("File %s couldn't be read from server.", path)
+	}
+
+	return file, nil
+}
+
+// Delete a file.
+func (f *filesystem) Delete(path string) error {
+	if err := os.Remove(f.makePath(path)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Remove an empty directory.
+func (f *filesystem) RemoveDir(path string) error {
+	info, err := os.Lstat(f.makePath(path))
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory.", path)
+	}
+
+	return os.Remove(f.makePath(path))
+}
+
+// List the contents of a directory.
+func (f *filesystem) List(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(f.makePath(path))
+}
+
+// Get the details of a file or directory.
+func (f *filesystem) Stat(path string) (os.FileInfo, error) {
+	return os.Lstat(f.makePath(path))
+}
+
+// Rename or move a file.
+func (f *filesystem) Rename(from, to string) error {
+	if err := f.MkDir(filepath.Dir(to)); err != nil {
+		return err
+	}
+
+	return os.Rename(f.makePath(from), f.makePath(to))
+}
+
+// Create a symlink. It's created aside and renamed over
+// the existing one, so the switch is atomic.
+func (f *filesystem) Symlink(target, link string) error {
+	if err := f.MkDir(filepath.Dir(link)); err != nil {
+		return err
+	}
+
+	temp := f.makePath(link) + ".steer-link"
+	os.Remove(temp)
+
+	if err := os.Symlink(filepath.FromSlash(target), temp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, f.makePath(link)); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return nil
+}
+
+// Change the permissions of a file or directory.
+func (f *filesystem) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(f.makePath(path), mode)
+}
+
+// Execute a command locally, inside the base path.
+func (f *filesystem) Exec(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	cmdout := &bytes.Buffer{}
+	cmderr := &bytes.Buffer{}
+	cmd.Dir = f.basepath
+	cmd.Stdout = cmdout
+	cmd.Stderr = cmderr
+
+	if err := cmd.Run(); err != nil {
+		// Same as SSH, stdErr is more informative when it's
+		// there.
+		if cmderr.String() == "" {
+			return "", err
+		}
+
+		return "", fmt.Errorf("%s", cmderr.String())
+	}
+
+	return cmdout.String(), nil
+}
+
+// Close connection.
+func (f *filesystem) Close() {}
+
+// Append the basepath to path.
+func (f *filesystem) makePath(path string) string {
+	return filepath.Join(f.basepath, filepath.FromSlash(path))
+}
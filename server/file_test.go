@@ -0,0 +1,188 @@
+package server
+
+import (
+	"testing"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func TestFileDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-file")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.txt")
+	ioutil.WriteFile(source, []byte("contents"), 0644)
+
+	target := filepath.Join(dir, "target")
+	os.Mkdir(target, 0755)
+
+	driver, err := ConnectFile(Params{Path: target})
+	if err != nil {
+		t.Fatalf("Couldn't connect to directory: %s", err.Error())
+	}
+
+	if err = Manage(driver).Upload(source, "nested/dir/file.txt"); err != nil {
+		t.Fatalf("File couldn't be uploaded: %s", err.Error())
+	}
+
+	actual, err := Manage(driver).Read("nested/dir/file.txt")
+	if err != nil || actual != "contents" {
+		t.Fatalf("Expected contents but got %s", actual)
+	}
+
+	out, err := driver.Exec("ls nested/dir")
+	if err != nil || strings.TrimSpace(out) != "file.txt" {
+		t.Fatalf("Expected command to run inside the base path, but got %s", out)
+	}
+
+	if err = driver.Delete("nested/dir/file.txt"); err != nil {
+		t.Fatalf("File couldn't be deleted: %s", err.Error())
+	}
+
+	if _, err = Manage(driver).Read("nested/dir/file.txt"); err == nil {
+		t.Fatalf("File should have been deleted.")
+	}
+}
+
+func TestFileDriverRefusesRoot(t *testing.T) {
+	if _, err := ConnectFile(Params{Path: "/"}); err == nil {
+		t.Fatalf("Deploying on the root directory should be refused.")
+	}
+}
+
+func TestFileDriverOperations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-file")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.txt")
+	ioutil.WriteFile(source, []byte("contents"), 0644)
+
+	target := filepath.Join(dir, "target")
+	os.Mkdir(target, 0755)
+
+	driver, _ := ConnectFile(Params{Path: target})
+	Manage(driver).Upload(source, "releases/1/index.html")
+
+	if err = driver.Rename("releases/1/index.html", "releases/2/index.html"); err != nil {
+		t.Fatalf("File couldn't be renamed: %s", err.Error())
+	}
+
+	files, err := driver.List("releases")
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 directories in releases, but got %d", len(files))
+	}
+
+	if err = driver.RemoveDir("releases/1"); err != nil {
+		t.Fatalf("Directory couldn't be removed: %s", err.Error())
+	}
+
+	if err = driver.RemoveDir("releases/2"); err == nil {
+		t.Fatalf("Directory that isn't empty shouldn't be removed.")
+	}
+
+	// Replacing a symlink switches it to the new target.
+	driver.Symlink("releases/1", "current")
+	if err = driver.Symlink("releases/2", "current"); err != nil {
+		t.Fatalf("Symlink couldn't be created: %s", err.Error())
+	}
+
+	info, err := driver.Stat("current")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected current to be a symlink.")
+	}
+
+	actual, err := Manage(driver).Read("current/index.html")
+	if err != nil || actual != "contents" {
+		t.Fatalf("Expected the symlink to point to the release, but got %s", actual)
+	}
+
+	if err = driver.Chmod("releases/2/index.html", 0600); err != nil {
+		t.Fatalf("Permissions couldn't be changed: %s", err.Error())
+	}
+
+	if info, _ = driver.Stat("releases/2/index.html"); info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected permissions 0600, but got %o", info.Mode().Perm())
+	}
+}
+
+func TestFileDriverStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-file")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	conn := Manage(&filesystem{basepath: dir})
+	contents := strings.Repeat("steer\n", 1000)
+
+	if err = conn.Put(strings.NewReader(contents), "logs/.steer-log"); err != nil {
+		t.Fatalf("Contents couldn't be written: %s", err.Error())
+	}
+
+	actual, err := conn.Read("logs/.steer-log")
+	if err != nil || actual != contents {
+		t.Fatalf("Expected %d bytes but got %d", len(contents), len(actual))
+	}
+}
+
+// Reader that fails halfway through.
+type failingReader struct {
+	sent bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, errors.New("Connection lost.")
+	}
+
+	r.sent = true
+
+	return copy(p, "partial"), nil
+}
+
+func TestSafeUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-file")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	conn := Manage(&filesystem{basepath: dir})
+	conn.Safeupload = true
+
+	if err = conn.Put(strings.NewReader("complete"), "dir/index.php"); err != nil {
+		t.Fatalf("File couldn't be uploaded: %s", err.Error())
+	}
+
+	// A failed transfer leaves the previous file in place.
+	if err = conn.Put(&failingReader{}, "dir/index.php"); err == nil {
+		t.Fatalf("A failed transfer should return an error.")
+	}
+
+	actual, err := conn.Read("dir/index.php")
+	if err != nil || actual != "complete" {
+		t.Fatalf("Expected complete but got %s", actual)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "dir"))
+	if len(files) != 1 {
+		t.Fatalf("Expected the temporary file to be removed.")
+	}
+	// A failed rename carries the reason.
+	if err = conn.Put(strings.NewReader("complete"), "dir"); err == nil || errors.Unwrap(err) == nil {
+		t.Fatalf("Expected the rename error to be wrapped, but got %v", err)
+	}
+}
@@ -1,8 +1,8 @@
 package server
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -13,16 +13,38 @@ import (
 type ftp struct {
 	conn     *goftp.Client
 	basepath string
+	created  *createdDirs
 	mutex    *sync.Mutex
+	// Control connection for SITE commands, opened on first
+	// use and kept for the rest of the session.
+	raw      goftp.RawConn
+	rawmutex *sync.Mutex
 }
 
 // Connect to the FTP server.
 func ConnectFtp(cfg Params) (*ftp, error) {
-	conn, err := goftp.DialConfig(goftp.Config{
+	ftpcfg := goftp.Config{
 		User:               cfg.Username,
 		Password:           cfg.Password,
 		ConnectionsPerHost: cfg.Maxclients,
-	}, fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
+	}
+
+	// FTPS either upgrades a plain connection with "AUTH TLS"
+	// or talks TLS right from the start.
+	if cfg.Scheme == "ftps" || cfg.Scheme == "ftps-implicit" {
+		tlscfg, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		ftpcfg.TLSConfig = tlscfg
+		ftpcfg.TLSMode = goftp.TLSExplicit
+		if cfg.Scheme == "ftps-implicit" {
+			ftpcfg.TLSMode = goftp.TLSImplicit
+		}
+	}
+
+	conn, err := goftp.DialConfig(ftpcfg, fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't connect to FTP server. System response: %s\n", err.Error())
 	}
@@ -30,7 +52,9 @@ func ConnectFtp(cfg Params) (*ftp, error) {
 	return &ftp{
 		conn:     conn,
 		basepath: cfg.Path,
+		created:  newCreatedDirs(),
 		mutex:    &sync.Mutex{},
+		rawmutex: &sync.Mutex{},
 	}, nil
 }
 
@@ -44,44 +68,133 @@ func (f *ftp) MkDir(path string) error {
 	return nil
 }
 
-// Upload a file.
-func (f *ftp) Upload(path, destination string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("%s couldn't be opened. Make sure it exists.\n", path)
+// Write the contents of a reader to a file.
+func (f *ftp) Put(r io.Reader, destination string) error {
+	if err := f.MkDir(remotepath.Dir(destination)); err != nil {
+		return err
+	}
+
+	if err := f.conn.Store(f.makePath(destination), r); err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
 	}
 
-	defer file.Close()
+	return nil
+}
+
+// Open a file for reading. The transfer runs as the file is
+// read, so errors may come up while reading.
+func (f *ftp) Get(path string) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
 
-	if err = f.MkDir(remotepath.Dir(destination)); err != nil {
+	go func() {
+		if err := f.conn.Retrieve(f.makePath(path), writer); err != nil {
+			writer.CloseWithError(fmt.Errorf("File %s couldn't be read from server.", path))
+			return
+		}
+
+		writer.Close()
+	}()
+
+	return reader, nil
+}
+
+// Delete a file.
+func (f *ftp) Delete(path string) error {
+	if err := f.conn.Delete(f.makePath(path)); err != nil {
 		return err
 	}
 
-	if err = f.conn.Store(f.makePath(destination), file); err != nil {
-		return fmt.Errorf("%s couldn't be uploaded.\n", path)
+	return nil
+}
+
+// Remove an empty directory.
+func (f *ftp) RemoveDir(path string) error {
+	if err := f.conn.Rmdir(f.makePath(path)); err != nil {
+		return err
 	}
 
+	f.created.remove(f.makePath(path))
+
 	return nil
 }
 
-// Read a file's contents.
-func (f *ftp) Read(path string) (string, error) {
-	contents := &bytes.Buffer{}
-	err := f.conn.Retrieve(f.makePath(path), contents)
+// List the contents of a directory.
+func (f *ftp) List(path string) ([]os.FileInfo, error) {
+	return f.conn.ReadDir(f.makePath(path))
+}
 
-	if err != nil {
-		return "", fmt.Errorf("File %s couldn't be read from server.", path)
+// Get the details of a file or directory.
+func (f *ftp) Stat(path string) (os.FileInfo, error) {
+	info, err := f.conn.Stat(f.makePath(path))
+	if err == nil {
+		return info, nil
 	}
 
-	return contents.String(), nil
+	// Servers without MLST can still list the parent
+	// directory.
+	files, lerr := f.List(remotepath.Dir(path))
+	if lerr != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.Name() == remotepath.Base(path) {
+			return file, nil
+		}
+	}
+
+	return nil, err
 }
 
-// Delete a file.
-func (f *ftp) Delete(path string) error {
-	if err := f.conn.Delete(f.makePath(path)); err != nil {
+// Rename or move a file.
+func (f *ftp) Rename(from, to string) error {
+	if err := f.MkDir(remotepath.Dir(to)); err != nil {
+		return err
+	}
+
+	if err := f.conn.Rename(f.makePath(from), f.makePath(to)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Create a symlink.
+func (f *ftp) Symlink(target, link string) error {
+	return &UnsupportedError{Scheme: "FTP", Operation: "symlinks"}
+}
+
+// Change permissions with SITE CHMOD, which most servers
+// support, but isn't part of the standard. Commands share a
+// single connection, so they don't log in for every file.
+func (f *ftp) Chmod(path string, mode os.FileMode) error {
+	f.rawmutex.Lock()
+	defer f.rawmutex.Unlock()
+
+	if f.raw == nil {
+		raw, err := f.conn.OpenRawConn()
+		if err != nil {
+			return err
+		}
+
+		f.raw = raw
+	}
+
+	code, msg, err := f.raw.SendCommand("SITE CHMOD %o %s", mode.Perm(), f.makePath(path))
+	if err != nil {
+		// A broken connection is opened again next time.
+		f.raw.Close()
+		f.raw = nil
 		return err
 	}
 
+	switch {
+	case code == 500 || code == 502 || code == 504:
+		return &UnsupportedError{Scheme: "This FTP server", Operation: "changing permissions"}
+	case code >= 300:
+		return fmt.Errorf("Permissions of %s couldn't be changed. System response: %s", path, msg)
+	}
+
 	return nil
 }
 
@@ -92,6 +205,13 @@ func (f *ftp) Exec(command string) (string, error) {
 
 // Close connection.
 func (f *ftp) Close() {
+	f.rawmutex.Lock()
+	if f.raw != nil {
+		f.raw.Close()
+		f.raw = nil
+	}
+	f.rawmutex.Unlock()
+
 	f.conn.Close()
 }
 
@@ -105,7 +225,7 @@ func (f *ftp) createDirs(dir string) error {
 	components := strings.Split(dir, string(os.PathSeparator))
 	currentDir := strings.TrimRight(f.basepath, "/")
 
-	if directoryAlreadyCreated(f.makePath(dir)) {
+	if f.created.has(f.makePath(dir)) {
 		return nil
 	}
 
@@ -125,7 +245,7 @@ func (f *ftp) createDirs(dir string) error {
 				return err
 			}
 
-			addToCreatedDirs(currentDir)
+			f.created.add(currentDir)
 		}
 	}
 
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"path/filepath"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Returned when the host isn't in known_hosts yet. It carries
+// the key so it can be trusted after asking the user.
+type UnknownHostError struct {
+	Host       string
+	Key        ssh.PublicKey
+	knownhosts string
+}
+
+func (e *UnknownHostError) Error() string {
+	return fmt.Sprintf("The authenticity of host %s can't be established.\n%s key fingerprint is %s.", e.Host, e.Key.Type(), e.Fingerprint())
+}
+
+// Fingerprint of the host key in OpenSSH's format.
+func (e *UnknownHostError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+// Append the host key to known_hosts, so following connections
+// are verified against it.
+func (e *UnknownHostError) Trust() error {
+	if err := os.MkdirAll(filepath.Dir(e.knownhosts), 0700); err != nil {
+		return fmt.Errorf("%s couldn't be created.\n", filepath.Dir(e.knownhosts))
+	}
+
+	f, err := os.OpenFile(e.knownhosts, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be opened for writing.\n", e.knownhosts)
+	}
+
+	defer f.Close()
+
+	if _, err = f.WriteString(knownhosts.Line([]string{e.Host}, e.Key) + "\n"); err != nil {
+		return fmt.Errorf("Host key couldn't be added to %s.\n", e.knownhosts)
+	}
+
+	return nil
+}
+
+// Build the host key callback. Verification errors are stored
+// in failure, as the ssh package flattens them into strings.
+func hostKeyCallback(cfg Params, failure *error) (ssh.HostKeyCallback, error) {
+	// A pinned fingerprint takes precedence over known_hosts,
+	// which is what CI environments want.
+	if cfg.Hostkey != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !fingerprintMatches(cfg.Hostkey, key) {
+				*failure = fmt.Errorf("Host key for %s doesn't match the pinned fingerprint.\nExpected %s, but the server sent %s.\n", hostname, cfg.Hostkey, ssh.FingerprintSHA256(key))
+				return *failure
+			}
+
+			return nil
+		}, nil
+	}
+
+	file := cfg.Knownhosts
+
+	check, err := knownhosts.New(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s couldn't be read. System response: %s\n", file, err.Error())
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// A missing known_hosts means no host is known yet.
+		if check == nil {
+			*failure = &UnknownHostError{Host: hostname, Key: key, knownhosts: file}
+			return *failure
+		}
+
+		err := check(hostname, remote, key)
+		switch e := err.(type) {
+		case nil:
+			return nil
+		case *knownhosts.KeyError:
+			if len(e.Want) == 0 {
+				*failure = &UnknownHostError{Host: hostname, Key: key, knownhosts: file}
+			} else {
+				*failure = fmt.Errorf("Host key for %s has changed and doesn't match %s.\nThe server sent %s. Someone could be intercepting the connection.\nIf the change is expected, remove the old key and connect again.\n", hostname, e.Want[0].String(), ssh.FingerprintSHA256(key))
+			}
+		case *knownhosts.RevokedError:
+			*failure = fmt.Errorf("Host key for %s is revoked in %s.\n", hostname, e.Revoked.Filename)
+		default:
+			*failure = err
+		}
+
+		return *failure
+	}, nil
+}
+
+// Host key algorithms for the keys known_hosts has for the
+// address. Servers offer several keys and the first common
+// algorithm wins, so without this a host known by its Ed25519
+// key would fail when it also has an RSA one. No preference is
+// given for pinned fingerprints and unknown hosts.
+func hostKeyAlgorithms(cfg Params, address string) []string {
+	if cfg.Hostkey != "" {
+		return nil
+	}
+
+	check, err := knownhosts.New(cfg.Knownhosts)
+	if err != nil {
+		return nil
+	}
+
+	// Checking a key that can't match lists every known one.
+	e, ok := check(address, &net.TCPAddr{IP: net.IPv4zero}, probeKey{}).(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, known := range e.Want {
+		for _, algorithm := range keyAlgorithms(known.Key.Type()) {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+
+	return algorithms
+}
+
+// Signature algorithms a key type can be verified with.
+func keyAlgorithms(keytype string) []string {
+	switch keytype {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	}
+
+	return []string{keytype}
+}
+
+// Key that matches nothing in known_hosts.
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "probe" }
+func (probeKey) Marshal() []byte                              { return []byte("probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return fmt.Errorf("probe") }
+
+// Compare a fingerprint with a key. Both SHA256 and the
+// legacy MD5 formats are accepted.
+func fingerprintMatches(fingerprint string, key ssh.PublicKey) bool {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint == ssh.FingerprintSHA256(key)
+	}
+
+	return strings.ToLower(strings.TrimPrefix(fingerprint, "MD5:")) == ssh.FingerprintLegacyMD5(key)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"testing"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Generate a host key of the given type.
+func hostSigner(t *testing.T, keytype string) ssh.Signer {
+	var key interface{}
+	var err error
+
+	if keytype == "rsa" {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+
+	if err != nil {
+		t.Fatalf("Host key couldn't be generated: %s", err.Error())
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Host key couldn't be used: %s", err.Error())
+	}
+
+	return signer
+}
+
+// Write a known_hosts file with a key for each address.
+func writeKnownHosts(t *testing.T, keys map[string]ssh.PublicKey) string {
+	dir, err := ioutil.TempDir("", "steer-knownhosts")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	var lines []string
+	for address, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{address}, key))
+	}
+
+	file := filepath.Join(dir, "known_hosts")
+	if err = ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("known_hosts couldn't be written.")
+	}
+
+	return file
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known := hostSigner(t, "ed25519").PublicKey()
+	other := hostSigner(t, "ed25519").PublicKey()
+
+	file := writeKnownHosts(t, map[string]ssh.PublicKey{"example.com": known})
+	defer os.RemoveAll(filepath.Dir(file))
+
+	var failure error
+	check, err := hostKeyCallback(Params{Knownhosts: file}, &failure)
+	if err != nil {
+		t.Fatalf("Callback couldn't be built: %s", err.Error())
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	if err = check("example.com:22", remote, known); err != nil {
+		t.Fatalf("Expected the known key to be accepted, but got: %s", err.Error())
+	}
+
+	err = check("other.com:22", remote, other)
+	if unknown, ok := err.(*UnknownHostError); !ok || unknown.Host != "other.com:22" {
+		t.Fatalf("Expected an unknown host, but got %v", err)
+	}
+
+	err = check("example.com:22", remote, other)
+	if err == nil || !strings.Contains(err.Error(), "has changed") || failure != err {
+		t.Fatalf("Expected a changed key, but got %v", err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	rsakey := hostSigner(t, "rsa").PublicKey()
+	edkey := hostSigner(t, "ed25519").PublicKey()
+
+	file := writeKnownHosts(t, map[string]ssh.PublicKey{"example.com": rsakey, "[example.com]:2222": edkey})
+	defer os.RemoveAll(filepath.Dir(file))
+
+	cfg := Params{Knownhosts: file}
+
+	expected := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if actual := hostKeyAlgorithms(cfg, "example.com:22"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+
+	expected = []string{ssh.KeyAlgoED25519}
+	if actual := hostKeyAlgorithms(cfg, "example.com:2222"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+
+	// Unknown hosts and pinned fingerprints use the defaults.
+	if actual := hostKeyAlgorithms(cfg, "other.com:22"); actual != nil {
+		t.Fatalf("Expected no algorithms for an unknown host, but got %v", actual)
+	}
+
+	cfg.Hostkey = ssh.FingerprintSHA256(rsakey)
+	if actual := hostKeyAlgorithms(cfg, "example.com:22"); actual != nil {
+		t.Fatalf("Expected no algorithms for a pinned key, but got %v", actual)
+	}
+}
+
+// A server with several host keys is verified against the
+// one in known_hosts, not the first one it offers.
+func TestDialKnownHostKey(t *testing.T) {
+	edsigner := hostSigner(t, "ed25519")
+
+	servercfg := &ssh.ServerConfig{NoClientAuth: true}
+	servercfg.AddHostKey(hostSigner(t, "rsa"))
+	servercfg.AddHostKey(edsigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listener couldn't be opened: %s", err.Error())
+	}
+
+	defer listener.Close()
+
+	// The handshake only succeeds when the client accepts
+	// the host key.
+	handshake := make(chan error, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			handshake <- err
+			return
+		}
+
+		_, channels, requests, err := ssh.NewServerConn(conn, servercfg)
+		handshake <- err
+		if err != nil {
+			return
+		}
+
+		go ssh.DiscardRequests(requests)
+		for channel := range channels {
+			channel.Reject(ssh.Prohibited, "")
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	file := writeKnownHosts(t, map[string]ssh.PublicKey{"[127.0.0.1]:" + strconv.Itoa(addr.Port): edsigner.PublicKey()})
+	defer os.RemoveAll(filepath.Dir(file))
+
+	// SFTP itself is refused, only the host key matters.
+	ConnectSsh(Params{Host: "127.0.0.1", Port: addr.Port, Username: "steer", Password: "secret", Knownhosts: file})
+
+	if err := <-handshake; err != nil {
+		t.Fatalf("Expected the Ed25519 host key to be accepted, but got: %s", err.Error())
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection parameters for each jump host, in the order
+// they're dialed. Hops verify their host keys against
+// known_hosts, as a pinned key belongs to the server.
+func jumpHops(cfg Params) ([]Params, error) {
+	var hops []Params
+
+	for i, jumphost := range cfg.Jumphosts {
+		user, host, port, err := parseJumphost(jumphost)
+		if err != nil {
+			return nil, err
+		}
+
+		hop := cfg
+		hop.Host = host
+		hop.Port = port
+		hop.Hostkey = ""
+		hop.Jumphosts = nil
+
+		if user != "" {
+			hop.Username = user
+		}
+
+		// A dedicated key replaces the server's credentials.
+		if key := hopValue(cfg.Jumpkeys, i); key != "" {
+			hop.Privatekey = key
+			hop.Passphrase = hopValue(cfg.Jumpphrase, i)
+			hop.Password = ""
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// Value of a hop from a list with one for each. A single
+// value is shared by every hop.
+func hopValue(values []string, hop int) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	if hop < len(values) {
+		return values[hop]
+	}
+
+	return ""
+}
+
+// Parse a jump host in the form of [user@]host[:port].
+func parseJumphost(jumphost string) (string, string, int, error) {
+	user := ""
+	address := strings.TrimSpace(jumphost)
+
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		user, address = address[:i], address[i+1:]
+	}
+
+	host, port := address, 22
+
+	// A colon after the last bracket separates the port. IPv6
+	// addresses need to be in brackets to carry one.
+	if i := strings.LastIndex(address, ":"); i > strings.LastIndex(address, "]") {
+		h, p, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("Jump host '%s' isn't valid. Use the form user@host:port.", jumphost)
+		}
+
+		host = h
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("Jump host '%s' has an invalid port.", jumphost)
+		}
+	}
+
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		return "", "", 0, fmt.Errorf("Jump host '%s' doesn't have a host.", jumphost)
+	}
+
+	return user, host, port, nil
+}
+
+// Close SSH connections, the last one first.
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestParseJumphost(t *testing.T) {
+	cases := map[string]struct {
+		user string
+		host string
+		port int
+	}{
+		"bastion.example.com":       {"", "bastion.example.com", 22},
+		"admin@bastion.example.com": {"admin", "bastion.example.com", 22},
+		" admin@bastion:2200 ":      {"admin", "bastion", 2200},
+		"me@corp.com@bastion":       {"me@corp.com", "bastion", 22},
+		"[2001:db8::1]:2222":        {"", "2001:db8::1", 2222},
+		"admin@[2001:db8::1]":       {"admin", "2001:db8::1", 22},
+		"10.0.0.1:22":               {"", "10.0.0.1", 22},
+	}
+
+	for jumphost, expected := range cases {
+		user, host, port, err := parseJumphost(jumphost)
+		if err != nil || user != expected.user || host != expected.host || port != expected.port {
+			t.Errorf("Expected %q to be %v, but got %s %s %d (%v)", jumphost, expected, user, host, port, err)
+		}
+	}
+
+	for _, jumphost := range []string{"", "admin@", "bastion:port", "bastion:", ":22"} {
+		if _, _, _, err := parseJumphost(jumphost); err == nil {
+			t.Errorf("Expected %q to be invalid.", jumphost)
+		}
+	}
+}
+
+func TestJumpHops(t *testing.T) {
+	cfg := Params{
+		Host:       "web",
+		Username:   "deploy",
+		Password:   "secret",
+		Hostkey:    "SHA256:pinned",
+		Jumphosts:  []string{"admin@first", "second:2200", "third"},
+		Jumpkeys:   []string{"~/.ssh/first", "~/.ssh/second"},
+		Jumpphrase: []string{"one", ""},
+	}
+
+	hops, err := jumpHops(cfg)
+	if err != nil {
+		t.Fatalf("Hops couldn't be resolved: %s", err.Error())
+	}
+
+	var actual [][]string
+	for _, hop := range hops {
+		actual = append(actual, []string{hop.Username, hop.Host, hop.Privatekey, hop.Passphrase, hop.Password, hop.Hostkey})
+	}
+
+	// Hops without a key of their own use the server's
+	// credentials, but never its pinned host key.
+	expected := [][]string{
+		{"admin", "first", "~/.ssh/first", "one", "", ""},
+		{"deploy", "second", "~/.ssh/second", "", "", ""},
+		{"deploy", "third", "", "", "secret", ""},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v but got %v", expected, actual)
+	}
+
+	// A single key is shared by every hop.
+	cfg.Jumpkeys, cfg.Jumpphrase = []string{"~/.ssh/bastion"}, []string{"shared"}
+	hops, _ = jumpHops(cfg)
+	for _, hop := range hops {
+		if hop.Privatekey != "~/.ssh/bastion" || hop.Passphrase != "shared" {
+			t.Fatalf("Expected every hop to use the same key, but got %s", hop.Privatekey)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// Check if a private key is protected with a passphrase.
+func KeyNeedsPassphrase(file string) bool {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+
+	_, err = ssh.ParseRawPrivateKey(contents)
+	_, missing := err.(*ssh.PassphraseMissingError)
+
+	return missing
+}
+
+// Parse a private key, decrypting it with the passphrase
+// when it's protected.
+func parsePrivateKey(file, passphrase string) (ssh.Signer, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Private key %s couldn't be read.", file)
+	}
+
+	if !KeyNeedsPassphrase(file) {
+		signer, err := ssh.ParsePrivateKey(contents)
+		if err != nil {
+			return nil, fmt.Errorf("Private key %s couldn't be parsed: %s.", file, err.Error())
+		}
+
+		return signer, nil
+	}
+
+	if passphrase == "" {
+		return nil, fmt.Errorf("Private key %s is protected, but no passphrase was given.", file)
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(contents, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("Private key %s couldn't be decrypted. Check the passphrase.", file)
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"mime"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	remotepath "path"
+	"github.com/fadion/steer/rules"
+)
+
+type s3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathstyle bool
+	region    string
+	accesskey string
+	secretkey string
+	token     string
+	basepath  string
+	mimetypes bool
+	cache     []rules.Rule
+}
+
+// Error returned by the S3 API.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Result of listing the objects of a bucket.
+type s3List struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Payload hash of requests without a body.
+var emptyPayload = hex.EncodeToString(sha256.New().Sum(nil))
+
+// Connect to an S3 compatible bucket.
+func ConnectS3(cfg Params) (*s3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 needs a bucket to deploy to.\n")
+	}
+
+	// Keys fall back to the environment variables the AWS
+	// tools use.
+	accesskey := cfg.Accesskey
+	secretkey := cfg.Secretkey
+	if accesskey == "" && secretkey == "" {
+		accesskey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretkey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if accesskey == "" || secretkey == "" {
+		return nil, fmt.Errorf("S3 needs an access key and a secret key.\n")
+	}
+
+	// Without a custom endpoint, it's AWS with virtual hosted
+	// buckets. Other providers get path style requests, as
+	// that's what most of them support.
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", cfg.Bucket, cfg.Region)
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("S3 endpoint %s isn't valid.\n", endpoint)
+	}
+
+	s := &s3{
+		client:    &http.Client{},
+		endpoint:  u,
+		bucket:    cfg.Bucket,
+		pathstyle: cfg.Endpoint != "",
+		region:    cfg.Region,
+		accesskey: accesskey,
+		secretkey: secretkey,
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		basepath:  strings.Trim(cfg.Path, "/"),
+		mimetypes: cfg.Mimetypes,
+		cache:     cfg.Cache,
+	}
+
+	// Check that the bucket exists and the keys work.
+	res, err := s.request("HEAD", "", nil, nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't connect to S3. System response: %s\n", err.Error())
+	}
+
+	res.Body.Close()
+
+	return s, nil
+}
+
+// Create directory. Object storage doesn't have directories,
+// so there's nothing to do.
+func (s *s3) MkDir(path string) error {
+	return nil
+}
+
+// Write the contents of a reader to a file.
+func (s *s3) Put(r io.Reader, destination string) error {
+	// Uploads need their length upfront.
+	body, size, cleanup, err := sizedReader(r)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	defer cleanup()
+
+	headers := map[string]string{}
+
+	if s.mimetypes {
+		if mimetype := mime.TypeByExtension(remotepath.Ext(destination)); mimetype != "" {
+			headers["Content-Type"] = mimetype
+		}
+	}
+
+	if cache := s.cacheControl(destination); cache != "" {
+		headers["Cache-Control"] = cache
+	}
+
+	res, err := s.request("PUT", s.makePath(destination), nil, body, size, headers)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	res.Body.Close()
+
+	return nil
+}
+
+// Open a file for reading.
+func (s *s3) Get(path string) (io.ReadCloser, error) {
+	res, err := s.request("GET", s.makePath(path), nil, nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("File %s couldn't be read from server.", path)
+	}
+
+	return res.Body, nil
+}
+
+// Delete a file.
+func (s *s3) Delete(path string) error {
+	res, err := s.request("DELETE", s.makePath(path), nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	return nil
+}
+
+// Remove a directory. There's nothing to do, as they
+// don't exist.
+func (s *s3) RemoveDir(path string) error {
+	return nil
+}
+
+// List the contents of a directory. Keys are grouped by
+// slashes, so they look like files and directories.
+func (s *s3) List(path string) ([]os.FileInfo, error) {
+	prefix := s.makePath(path)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("delimiter", "/")
+
+	var files []os.FileInfo
+	for {
+		res, err := s.request("GET", "", query, nil, 0, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Directory %s couldn't be listed. System response: %s", path, err.Error())
+		}
+
+		result := s3List{}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Directory %s couldn't be listed.", path)
+		}
+
+		for _, object := range result.Contents {
+			files = append(files, &fileInfo{
+				name:    strings.TrimPrefix(object.Key, prefix),
+				size:    object.Size,
+				mode:    0644,
+				modtime: object.LastModified,
+			})
+		}
+
+		for _, dir := range result.CommonPrefixes {
+			files = append(files, &fileInfo{
+				name: strings.TrimSuffix(strings.TrimPrefix(dir.Prefix, prefix), "/"),
+				mode: os.ModeDir | 0755,
+			})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	return files, nil
+}
+
+// Get the details of a file or directory. A directory is
+// any prefix that has objects under it.
+func (s *s3) Stat(path string) (os.FileInfo, error) {
+	res, err := s.request("HEAD", s.makePath(path), nil, nil, 0, nil)
+	if err == nil {
+		res.Body.Close()
+		modtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+		return &fileInfo{
+			name:    remotepath.Base(path),
+			size:    res.ContentLength,
+			mode:    0644,
+			modtime: modtime,
+		}, nil
+	}
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s.makePath(path)+"/")
+	query.Set("max-keys", "1")
+
+	res, lerr := s.request("GET", "", query, nil, 0, nil)
+	if lerr != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	result := s3List{}
+	if xml.NewDecoder(res.Body).Decode(&result) != nil || len(result.Contents)+len(result.CommonPrefixes) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return &fileInfo{name: remotepath.Base(path), mode: os.ModeDir | 0755}, nil
+}
+
+// Rename a file. Objects can't be renamed, so it's copied
+// with its metadata and then deleted.
+func (s *s3) Rename(from, to string) error {
+	source := escapePath("/" + s.bucket + "/" + s.makePath(from))
+
+	res, err := s.request("PUT", s.makePath(to), nil, nil, 0, map[string]string{"X-Amz-Copy-Source": source})
+	if err != nil {
+		return fmt.Errorf("%s couldn't be renamed. System response: %s", from, err.Error())
+	}
+
+	res.Body.Close()
+
+	return s.Delete(from)
+}
+
+// Create a symlink.
+func (s *s3) Symlink(target, link string) error {
+	return &UnsupportedError{Scheme: "S3", Operation: "symlinks"}
+}
+
+// Change permissions.
+func (s *s3) Chmod(path string, mode os.FileMode) error {
+	return &UnsupportedError{Scheme: "S3", Operation: "permissions"}
+}
+
+// Execute a command on the server.
+func (s *s3) Exec(command string) (string, error) {
+	return "", fmt.Errorf("S3 doesn't support commands")
+}
+
+// Close connection.
+func (s *s3) Close() {}
+
+// Append the basepath to path. Keys don't start with a slash.
+func (s *s3) makePath(path string) string {
+	return strings.TrimLeft(remotepath.Join(s.basepath, path), "/")
+}
+
+// Find the cache control of a file, from the first rule
+// that matches.
+func (s *s3) cacheControl(path string) string {
+	for _, rule := range s.cache {
+		if rules.Match(rule.Pattern, path) {
+			return rule.Value
+		}
+	}
+
+	return ""
+}
+
+// Send a signed request. Responses with an error status are
+// turned into errors.
+func (s *s3) request(method, key string, query url.Values, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	uri := "/" + key
+	if s.pathstyle {
+		uri = strings.TrimRight("/"+s.bucket+uri, "/")
+	}
+
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + uri
+	u.RawPath = escapePath(s.endpoint.Path) + escapePath(uri)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bodies are streamed, so their hash isn't known.
+	payload := emptyPayload
+	if body != nil {
+		payload = "UNSIGNED-PAYLOAD"
+		req.ContentLength = size
+
+		// An unknown length would be sent chunked, which
+		// S3 doesn't accept.
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	s.sign(req, payload, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		apierr := s3Error{}
+		contents, _ := ioutil.ReadAll(res.Body)
+		if xml.Unmarshal(contents, &apierr) == nil && apierr.Code != "" {
+			return nil, fmt.Errorf("%s: %s", apierr.Code, apierr.Message)
+		}
+
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+
+	return res, nil
+}
+
+// Sign a request with AWS Signature Version 4.
+// Based on https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *s3) sign(req *http.Request, payload string, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	// Host and every amz header are signed, plus the content
+	// and range headers when they're set.
+	signed := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "cache-control" || lower == "range" {
+			signed[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	var names []string
+	for name := range signed {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	hash := sha256.Sum256([]byte(canonical))
+	tosign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.secretkey), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, tosign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accesskey, scope, signedHeaders, signature))
+}
+
+// Sort and encode query parameters for signing.
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escapeUri(key)+"="+escapeUri(value))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// Encode a path, keeping the slashes.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escapeUri(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// Encode everything but the unreserved characters of RFC 3986.
+func escapeUri(value string) string {
+	var out bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			out.WriteByte(c)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+
+	return out.String()
+}
+
+// HMAC with SHA256.
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
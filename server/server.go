@@ -1,17 +1,47 @@
 package server
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"io/ioutil"
+	remotepath "path"
+	"github.com/fadion/steer/rules"
+)
+
 // Holds the connection driver.
 type Connection struct {
 	Driver Driver
+	// Upload files to a temporary name and rename them into
+	// place, so a failed transfer doesn't leave them truncated.
+	Safeupload bool
 }
 
 // Connection parameters.
 type Params struct {
+	Scheme     string
 	Host       string
 	Port       int
 	Username   string
 	Password   string
 	Privatekey string
+	Passphrase string
+	Cacert     string
+	Clientcert string
+	Clientkey  string
+	Skipverify bool
+	Knownhosts string
+	Hostkey    string
+	Jumphosts  []string
+	Jumpkeys   []string
+	Jumpphrase []string
+	Bucket     string
+	Region     string
+	Endpoint   string
+	Accesskey  string
+	Secretkey  string
+	Mimetypes  bool
+	Cache      []rules.Rule
 	Path       string
 	Maxclients int
 }
@@ -30,23 +60,69 @@ func (c *Connection) MkDir(path string) error {
 	return nil
 }
 
-// Upload a file.
+// Upload a local file.
 func (c *Connection) Upload(path, destination string) error {
-	if err := c.Driver.Upload(path, destination); err != nil {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be opened. Make sure it exists.\n", path)
+	}
+
+	defer file.Close()
+
+	return c.Put(file, destination)
+}
+
+// Write the contents of a reader to a file.
+func (c *Connection) Put(r io.Reader, destination string) error {
+	if c.Safeupload {
+		return c.safePut(r, destination)
+	}
+
+	if err := c.Driver.Put(r, destination); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Write to a hidden sibling of the destination and rename it
+// into place once the transfer is complete.
+func (c *Connection) safePut(r io.Reader, destination string) error {
+	temp := remotepath.Join(remotepath.Dir(destination), "."+remotepath.Base(destination)+".steer-upload")
+
+	if err := c.Driver.Put(r, temp); err != nil {
+		c.Driver.Delete(temp)
 		return err
 	}
 
+	if err := c.Driver.Rename(temp, destination); err != nil {
+		c.Driver.Delete(temp)
+		return fmt.Errorf("%s couldn't be renamed into place. System response: %w", destination, err)
+	}
+
 	return nil
 }
 
+// Open a file for reading. It has to be closed when done.
+func (c *Connection) Get(path string) (io.ReadCloser, error) {
+	return c.Driver.Get(path)
+}
+
 // Read a file contents.
 func (c *Connection) Read(path string) (string, error) {
-	contents, err := c.Driver.Read(path)
+	r, err := c.Driver.Get(path)
 	if err != nil {
 		return "", err
 	}
 
-	return contents, nil
+	defer r.Close()
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("File %s couldn't be read from server.", path)
+	}
+
+	return string(contents), nil
 }
 
 // Delete a file.
@@ -58,6 +134,54 @@ func (c *Connection) Delete(path string) error {
 	return nil
 }
 
+// Remove an empty directory.
+func (c *Connection) RemoveDir(path string) error {
+	if err := c.Driver.RemoveDir(path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// List the contents of a directory.
+func (c *Connection) List(path string) ([]os.FileInfo, error) {
+	return c.Driver.List(path)
+}
+
+// Get the details of a file or directory. Symlinks
+// aren't followed.
+func (c *Connection) Stat(path string) (os.FileInfo, error) {
+	return c.Driver.Stat(path)
+}
+
+// Rename or move a file, replacing the destination.
+func (c *Connection) Rename(from, to string) error {
+	if err := c.Driver.Rename(from, to); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Create a symlink pointing to target, replacing the
+// link if it exists.
+func (c *Connection) Symlink(target, link string) error {
+	if err := c.Driver.Symlink(target, link); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Change the permissions of a file or directory.
+func (c *Connection) Chmod(path string, mode os.FileMode) error {
+	if err := c.Driver.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Execute a command on the server.
 func (c *Connection) Exec(command string) (string, error) {
 	return c.Driver.Exec(command)
@@ -8,6 +8,8 @@ import (
 	"bufio"
 	"io"
 	"bytes"
+	"net"
+	"strconv"
 	remotepath "path"
 	srv "github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -16,47 +18,124 @@ import (
 type sftp struct {
 	client   *srv.Client
 	conn     *ssh.Client
+	hops     []*ssh.Client
 	basepath string
+	created  *createdDirs
 }
 
 // Connect to the SFTP server.
 func ConnectSsh(cfg Params) (*sftp, error) {
-	var method []ssh.AuthMethod
+	hopcfgs, err := jumpHops(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	if cfg.Privatekey != "" {
-		if mth, err := parsePrivateKey(cfg.Privatekey); err == nil {
-			method = append(method, mth)
+	// Each jump host is dialed through the previous one,
+	// and the server through the last of them.
+	var hops []*ssh.Client
+	var through *ssh.Client
+	for _, hopcfg := range hopcfgs {
+		through, err = dialSsh(hopcfg, through, fmt.Sprintf("jump host %s", hopcfg.Host))
+		if err != nil {
+			closeClients(hops)
+			return nil, err
 		}
-	} else {
-		method = append(method, ssh.Password(cfg.Password))
-	}
 
-	conncfg := &ssh.ClientConfig{
-		User: cfg.Username,
-		Auth: method,
-		Config: ssh.Config{
-			Ciphers: []string{"aes128-cbc", "hmac-sha1"},
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		hops = append(hops, through)
 	}
 
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), conncfg)
+	conn, err := dialSsh(cfg, through, "SFTP server")
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't connect to SFTP server. System response: %s\n", err.Error())
+		closeClients(hops)
+		return nil, err
 	}
 
 	client, err := srv.NewClient(conn)
 	if err != nil {
+		conn.Close()
+		closeClients(hops)
 		return nil, fmt.Errorf("Couldn't connect to SFTP server. System response: %s\n", err.Error())
 	}
 
 	return &sftp{
 		client:   client,
 		conn:     conn,
+		hops:     hops,
 		basepath: cfg.Path,
+		created:  newCreatedDirs(),
 	}, nil
 }
 
+// Open an SSH connection, tunneled through another
+// connection if one is given.
+func dialSsh(cfg Params, through *ssh.Client, name string) (*ssh.Client, error) {
+	auth := newSshAuth(cfg)
+	defer auth.close()
+
+	var hostkeyErr error
+	hostkey, err := hostKeyCallback(cfg, &hostkeyErr)
+	if err != nil {
+		return nil, err
+	}
+
+	conncfg := &ssh.ClientConfig{
+		User: cfg.Username,
+		Auth: auth.methods,
+		Config: ssh.Config{
+			Ciphers: sshCiphers(),
+		},
+		HostKeyCallback: hostkey,
+	}
+
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conncfg.HostKeyAlgorithms = hostKeyAlgorithms(cfg, address)
+
+	var conn *ssh.Client
+	if through == nil {
+		conn, err = ssh.Dial("tcp", address, conncfg)
+	} else {
+		conn, err = dialThrough(through, address, conncfg)
+	}
+
+	if err != nil {
+		// Host key failures are returned as they are, so
+		// unknown hosts can be trusted interactively.
+		if hostkeyErr != nil {
+			return nil, hostkeyErr
+		}
+
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, auth.report(cfg)
+		}
+
+		return nil, fmt.Errorf("Couldn't connect to %s. System response: %s\n", name, err.Error())
+	}
+
+	return conn, nil
+}
+
+// Open an SSH connection over a tunnel of another connection.
+func dialThrough(through *ssh.Client, address string, conncfg *ssh.ClientConfig) (*ssh.Client, error) {
+	tunnel, err := through.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(tunnel, address, conncfg)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Ciphers offered to servers. CBC isn't among the library
+// defaults, but older servers may support nothing else.
+func sshCiphers() []string {
+	return append(ssh.SupportedAlgorithms().Ciphers, ssh.InsecureCipherAES128CBC)
+}
+
 // Create directory.
 func (s *sftp) MkDir(path string) error {
 	if err := s.createDirs(s.makePath(path)); err != nil {
@@ -66,67 +145,122 @@ func (s *sftp) MkDir(path string) error {
 	return nil
 }
 
-// Upload a file.
-func (s *sftp) Upload(path, destination string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("%s couldn't be opened. Make sure it exists.\n", path)
-	}
-
-	defer file.Close()
-
-	if err = s.MkDir(remotepath.Dir(destination)); err != nil {
+// Write the contents of a reader to a file.
+func (s *sftp) Put(r io.Reader, destination string) error {
+	if err := s.MkDir(remotepath.Dir(destination)); err != nil {
 		return err
 	}
 
 	f, err := s.client.Create(s.makePath(destination))
 	if err != nil {
-		return fmt.Errorf("%s couldn't be uploaded.\n", path)
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
 	}
 
-	defer f.Close()
+	// The server may only report a failed write when the
+	// file is closed.
+	_, err = f.ReadFrom(bufio.NewReader(r))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 
-	_, err = f.ReadFrom(bufio.NewReader(file))
 	if err != nil {
-		s.Delete(s.makePath(destination))
-		return fmt.Errorf("%s couldn't be uploaded.\n", path)
+		s.Delete(destination)
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
 	}
 
 	return nil
 }
 
-// Read a file's contents.
-func (s *sftp) Read(path string) (string, error) {
+// Open a file for reading.
+func (s *sftp) Get(path string) (io.ReadCloser, error) {
 	file, err := s.client.Open(s.makePath(path))
 	if err != nil {
-		return "", fmt.Errorf("File %s couldn't be read from server.", path)
+		return nil, fmt.Errorf("File %s couldn't be read from server.", path)
 	}
 
-	defer file.Close()
+	return file, nil
+}
 
-	buffer := make([]byte, 512)
-	read := 0
-	for {
-		read, err = file.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return "", fmt.Errorf("File %s couldn't be read from server.", path)
+// Delete a file.
+func (s *sftp) Delete(path string) error {
+	if err := s.client.Remove(s.makePath(path)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Remove an empty directory.
+func (s *sftp) RemoveDir(path string) error {
+	if err := s.client.RemoveDirectory(s.makePath(path)); err != nil {
+		return err
+	}
+
+	s.created.remove(s.makePath(path))
+
+	return nil
+}
+
+// List the contents of a directory.
+func (s *sftp) List(path string) ([]os.FileInfo, error) {
+	return s.client.ReadDir(s.makePath(path))
+}
+
+// Get the details of a file or directory.
+func (s *sftp) Stat(path string) (os.FileInfo, error) {
+	return s.client.Lstat(s.makePath(path))
+}
+
+// Rename or move a file.
+func (s *sftp) Rename(from, to string) error {
+	if err := s.MkDir(remotepath.Dir(to)); err != nil {
+		return err
+	}
+
+	// OpenSSH's extension replaces the destination atomically,
+	// like rename(2).
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(s.makePath(from), s.makePath(to))
+	}
+
+	err := s.client.Rename(s.makePath(from), s.makePath(to))
+
+	// Plain SFTP doesn't overwrite on rename, so an existing
+	// file has to be removed first.
+	if err != nil {
+		if info, serr := s.client.Lstat(s.makePath(to)); serr == nil && !info.IsDir() {
+			if err = s.client.Remove(s.makePath(to)); err == nil {
+				err = s.client.Rename(s.makePath(from), s.makePath(to))
 			}
 		}
 	}
 
-	return string(buffer[:read]), nil
+	return err
 }
 
-// Delete a file.
-func (s *sftp) Delete(path string) error {
-	if err := s.client.Remove(s.makePath(path)); err != nil {
+// Create a symlink. An existing link is replaced, but not
+// a file or directory.
+func (s *sftp) Symlink(target, link string) error {
+	if err := s.MkDir(remotepath.Dir(link)); err != nil {
 		return err
 	}
 
-	return nil
+	if info, err := s.client.Lstat(s.makePath(link)); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and isn't a symlink.", link)
+		}
+
+		if err = s.client.Remove(s.makePath(link)); err != nil {
+			return err
+		}
+	}
+
+	return s.client.Symlink(target, s.makePath(link))
+}
+
+// Change the permissions of a file or directory.
+func (s *sftp) Chmod(path string, mode os.FileMode) error {
+	return s.client.Chmod(s.makePath(path), mode)
 }
 
 // Execute a command on the server.
@@ -163,7 +297,7 @@ func (s *sftp) Exec(command string) (string, error) {
 		if cmderr.String() == "" {
 			return "", err
 		} else {
-			return "", fmt.Errorf(cmderr.String())
+			return "", fmt.Errorf("%s", cmderr.String())
 		}
 	}
 
@@ -173,6 +307,8 @@ func (s *sftp) Exec(command string) (string, error) {
 // Close connection.
 func (s *sftp) Close() {
 	s.client.Close()
+	s.conn.Close()
+	closeClients(s.hops)
 }
 
 // Append the basepath to path.
@@ -188,29 +324,40 @@ func (s *sftp) createDirs(dir string) error {
 	var err error
 	ssh_fx_failure := uint32(4)
 
-	if directoryAlreadyCreated(dir) {
+	if s.created.has(dir) {
 		return nil
 	}
 
-	for _, name := range strings.Split(dir, string(os.PathSeparator)) {
+	// Keep absolute paths absolute, as they're otherwise
+	// relative to the user's home.
+	if strings.HasPrefix(dir, "/") {
+		parents = "/"
+	}
+
+	for _, name := range strings.Split(dir, "/") {
+		if name == "" {
+			continue
+		}
+
 		parents = path.Join(parents, name)
 		err = s.client.Mkdir(parents)
 		if status, ok := err.(*srv.StatusError); ok {
 			if status.Code == ssh_fx_failure {
 				var fi os.FileInfo
 				fi, err = s.client.Stat(parents)
-				if err == nil {
-					if !fi.IsDir() {
-						return nil
-					}
+				if err == nil && !fi.IsDir() {
+					err = fmt.Errorf("%s exists and isn't a directory.", parents)
 				}
 			}
 		}
 		if err != nil {
-			addToCreatedDirs(parents)
 			break
 		}
 	}
 
+	if err == nil {
+		s.created.add(dir)
+	}
+
 	return err
 }
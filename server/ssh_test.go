@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	srv "github.com/pkg/sftp"
+)
+
+// Driver connected to an in-process SFTP server, and a function
+// to disconnect it.
+func pipeSftp(t *testing.T, dir string) (*sftp, func()) {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+
+	server, err := srv.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	if err != nil {
+		t.Fatalf("SFTP server couldn't be started: %s", err.Error())
+	}
+
+	go server.Serve()
+
+	client, err := srv.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("SFTP client couldn't be started: %s", err.Error())
+	}
+
+	return &sftp{client: client, basepath: dir, created: newCreatedDirs()}, func() {
+		serverWriter.Close()
+		client.Close()
+	}
+}
+
+func TestSftpRenameReplaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-sftp")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "index.php"), []byte("old"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".index.php.steer-upload"), []byte("new"), 0644)
+
+	s, disconnect := pipeSftp(t, dir)
+	defer disconnect()
+
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); !ok {
+		t.Fatalf("Expected the server to support posix-rename.")
+	}
+
+	if err = s.Rename(".index.php.steer-upload", "index.php"); err != nil {
+		t.Fatalf("File couldn't be renamed: %s", err.Error())
+	}
+
+	contents, _ := ioutil.ReadFile(filepath.Join(dir, "index.php"))
+	if string(contents) != "new" {
+		t.Fatalf("Expected new but got %s", contents)
+	}
+}
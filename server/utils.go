@@ -1,16 +1,35 @@
 package server
 
 import (
-	"io"
 	"os"
-	"golang.org/x/crypto/ssh"
+	"fmt"
+	"io"
+	"bytes"
+	"io/ioutil"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"sync"
 )
 
-var createdDirs []string
+// Directories created on a server, so they aren't created
+// again for every file. Each driver keeps its own, as
+// uploads run concurrently.
+type createdDirs struct {
+	dirs  []string
+	mutex *sync.Mutex
+}
+
+func newCreatedDirs() *createdDirs {
+	return &createdDirs{mutex: &sync.Mutex{}}
+}
 
 // Check if a directory was already created.
-func directoryAlreadyCreated(dir string) bool {
-	for _, k := range createdDirs {
+func (c *createdDirs) has(dir string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, k := range c.dirs {
 		if dir == k {
 			return true
 		}
@@ -21,42 +40,123 @@ func directoryAlreadyCreated(dir string) bool {
 
 // Add a directory to the list of created directories if
 // it wasn't added before.
-func addToCreatedDirs(dir string) {
-	for _, k := range createdDirs {
+func (c *createdDirs) add(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, k := range c.dirs {
 		if dir == k {
 			return
 		}
 	}
 
-	createdDirs = append(createdDirs, dir)
+	c.dirs = append(c.dirs, dir)
 }
 
-// Parse private key.
-func parsePrivateKey(file string) (ssh.AuthMethod, error) {
-	f, err := os.Open(file)
+// Forget a removed directory and the ones inside it, so
+// they're created again when needed.
+func (c *createdDirs) remove(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var kept []string
+	for _, k := range c.dirs {
+		if k != dir && !strings.HasPrefix(k, strings.TrimRight(dir, "/")+"/") {
+			kept = append(kept, k)
+		}
+	}
+
+	c.dirs = kept
+}
+
+// Give a reader a known size, as some protocols need it
+// before sending. Readers that can't tell are spooled to a
+// temp file. The returned function cleans up when done.
+func sizedReader(r io.Reader) (io.ReadSeeker, int64, func(), error) {
+	nothing := func() {}
+
+	switch v := r.(type) {
+	case *os.File:
+		info, err := v.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			offset, _ := v.Seek(0, io.SeekCurrent)
+			return io.NewSectionReader(v, offset, info.Size()-offset), info.Size() - offset, nothing, nil
+		}
+	case *bytes.Buffer:
+		return bytes.NewReader(v.Bytes()), int64(v.Len()), nothing, nil
+	case *bytes.Reader, *strings.Reader:
+		// Both have a length of what's left to read.
+		sized := v.(interface {
+			io.ReaderAt
+			io.Seeker
+			Len() int
+		})
+
+		offset, _ := sized.Seek(0, io.SeekCurrent)
+		return io.NewSectionReader(sized, offset, int64(sized.Len())), int64(sized.Len()), nothing, nil
+	}
+
+	temp, err := ioutil.TempFile("", "steer-")
 	if err != nil {
-		return nil, err
+		return nil, 0, nothing, err
 	}
 
-	defer f.Close()
+	cleanup := func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}
 
-	buffer := make([]byte, 5*1024)
-	read := 0
-	for {
-		read, err = f.Read(buffer)
+	size, err := io.Copy(temp, r)
+	if err == nil {
+		_, err = temp.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		cleanup()
+		return nil, 0, nothing, err
+	}
+
+	return temp, size, cleanup, nil
+}
+
+// Build the TLS configuration for encrypted connections.
+func tlsConfig(cfg Params) (*tls.Config, error) {
+	tlscfg := &tls.Config{
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.Skipverify,
+	}
+
+	// A custom CA bundle replaces the system roots, which
+	// is what self-signed or private CAs need.
+	if cfg.Cacert != "" {
+		pem, err := ioutil.ReadFile(cfg.Cacert)
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, err
-			}
+			return nil, fmt.Errorf("CA certificate %s couldn't be read.\n", cfg.Cacert)
 		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA certificate %s doesn't contain any valid PEM certificate.\n", cfg.Cacert)
+		}
+
+		tlscfg.RootCAs = pool
 	}
 
-	key, err := ssh.ParsePrivateKey(buffer[0:read])
-	if err != nil {
-		return nil, err
+	if cfg.Clientcert != "" {
+		// The key is usually in a separate file, but a
+		// combined PEM works as well.
+		keyfile := cfg.Clientkey
+		if keyfile == "" {
+			keyfile = cfg.Clientcert
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.Clientcert, keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("Client certificate couldn't be loaded. System response: %s\n", err.Error())
+		}
+
+		tlscfg.Certificates = []tls.Certificate{cert}
 	}
 
-	return ssh.PublicKeys(key), nil
+	return tlscfg, nil
 }
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"net"
+	"net/http"
+	"net/url"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"hash"
+	"strconv"
+	remotepath "path"
+)
+
+type webdav struct {
+	client   *http.Client
+	base     *url.URL
+	basepath string
+	created  *createdDirs
+	username string
+	password string
+	mutex    *sync.Mutex
+	authlock *sync.Mutex
+	auth     string
+	digest   map[string]string
+	nc       int
+}
+
+// Properties returned by PROPFIND.
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				Length   int64  `xml:"getcontentlength"`
+				Modified string `xml:"getlastmodified"`
+				Type     struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// Connect to a WebDAV server.
+func ConnectWebdav(cfg Params) (*webdav, error) {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		MaxConnsPerHost: cfg.Maxclients,
+	}
+
+	scheme := "http"
+	if cfg.Scheme == "webdavs" {
+		tlscfg, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		scheme = "https"
+		transport.TLSClientConfig = tlscfg
+	}
+
+	w := &webdav{
+		client:   &http.Client{Transport: transport},
+		base:     &url.URL{Scheme: scheme, Host: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))},
+		basepath: cfg.Path,
+		created:  newCreatedDirs(),
+		username: cfg.Username,
+		password: cfg.Password,
+		mutex:    &sync.Mutex{},
+		authlock: &sync.Mutex{},
+	}
+
+	// Ask for the properties of the base path, which checks
+	// both the credentials and that the path exists.
+	res, err := w.request("PROPFIND", w.makePath(""), nil, 0, map[string]string{"Depth": "0"})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't connect to WebDAV server. System response: %s\n", err.Error())
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Path %s doesn't exist on the WebDAV server.\n", cfg.Path)
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("Couldn't connect to WebDAV server. System response: %s\n", res.Status)
+	}
+
+	return w, nil
+}
+
+// Create directory.
+func (w *webdav) MkDir(path string) error {
+	// Try creating all the directories in the path.
+	if err := w.createDirs(path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Write the contents of a reader to a file.
+func (w *webdav) Put(r io.Reader, destination string) error {
+	// The body may be sent twice when authenticating, so it
+	// needs a known length and to be rewindable.
+	body, size, cleanup, err := sizedReader(r)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	defer cleanup()
+
+	if err = w.MkDir(remotepath.Dir(destination)); err != nil {
+		return err
+	}
+
+	res, err := w.request("PUT", w.makePath(destination), body, size, nil)
+	if err != nil {
+		return fmt.Errorf("%s couldn't be uploaded.\n", destination)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s couldn't be uploaded. System response: %s\n", destination, res.Status)
+	}
+
+	return nil
+}
+
+// Open a file for reading.
+func (w *webdav) Get(path string) (io.ReadCloser, error) {
+	res, err := w.request("GET", w.makePath(path), nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("File %s couldn't be read from server.", path)
+	}
+
+	if res.StatusCode >= 300 {
+		res.Body.Close()
+		return nil, fmt.Errorf("File %s couldn't be read from server.", path)
+	}
+
+	return res.Body, nil
+}
+
+// Delete a file.
+func (w *webdav) Delete(path string) error {
+	res, err := w.request("DELETE", w.makePath(path), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s couldn't be deleted. System response: %s", path, res.Status)
+	}
+
+	return nil
+}
+
+// Remove an empty directory. DELETE removes collections
+// with everything inside, so it's checked to be empty first.
+func (w *webdav) RemoveDir(path string) error {
+	files, err := w.List(path)
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		return fmt.Errorf("Directory %s isn't empty.", path)
+	}
+
+	res, err := w.request("DELETE", w.makePath(path)+"/", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Directory %s couldn't be removed. System response: %s", path, res.Status)
+	}
+
+	w.created.remove(w.makePath(path))
+
+	return nil
+}
+
+// List the contents of a directory.
+func (w *webdav) List(path string) ([]os.FileInfo, error) {
+	files, err := w.propfind(w.makePath(path)+"/", "1")
+	if err != nil {
+		return nil, err
+	}
+
+	// The collection itself is part of the response.
+	var out []os.FileInfo
+	for href, file := range files {
+		if strings.TrimRight(href, "/") != strings.TrimRight(w.makePath(path), "/") {
+			out = append(out, file)
+		}
+	}
+
+	return out, nil
+}
+
+// Get the details of a file or directory.
+func (w *webdav) Stat(path string) (os.FileInfo, error) {
+	files, err := w.propfind(w.makePath(path), "0")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		return file, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// Rename or move a file.
+func (w *webdav) Rename(from, to string) error {
+	if err := w.MkDir(remotepath.Dir(to)); err != nil {
+		return err
+	}
+
+	destination := *w.base
+	destination.Path = w.makePath(to)
+	destination.RawPath = escapePath(destination.Path)
+
+	res, err := w.request("MOVE", w.makePath(from), nil, 0, map[string]string{
+		"Destination": destination.String(),
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s couldn't be renamed. System response: %s", from, res.Status)
+	}
+
+	return nil
+}
+
+// Create a symlink.
+func (w *webdav) Symlink(target, link string) error {
+	return &UnsupportedError{Scheme: "WebDAV", Operation: "symlinks"}
+}
+
+// Change permissions.
+func (w *webdav) Chmod(path string, mode os.FileMode) error {
+	return &UnsupportedError{Scheme: "WebDAV", Operation: "permissions"}
+}
+
+// Execute a command on the server.
+func (w *webdav) Exec(command string) (string, error) {
+	return "", fmt.Errorf("WebDAV doesn't support commands")
+}
+
+// Close connection.
+func (w *webdav) Close() {
+	w.client.CloseIdleConnections()
+}
+
+// Append the basepath to path.
+func (w *webdav) makePath(path string) string {
+	return remotepath.Join("/", w.basepath, path)
+}
+
+// Create directories for a given path. Collections have to be
+// created one level at a time.
+func (w *webdav) createDirs(dir string) error {
+	components := strings.Split(dir, "/")
+	currentDir := w.makePath("")
+
+	if w.created.has(w.makePath(dir)) {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." {
+			continue
+		}
+
+		currentDir = remotepath.Join(currentDir, c)
+		if w.created.has(currentDir) {
+			continue
+		}
+
+		res, err := w.request("MKCOL", currentDir+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+
+		res.Body.Close()
+
+		// 405 means the collection already exists.
+		if res.StatusCode >= 300 && res.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("Directory %s couldn't be created. System response: %s", currentDir, res.Status)
+		}
+
+		w.created.add(currentDir)
+	}
+
+	return nil
+}
+
+// Ask for the properties of a resource and, with a depth of
+// 1, those of its members. They're keyed by their path.
+func (w *webdav) propfind(path, depth string) (map[string]os.FileInfo, error) {
+	res, err := w.request("PROPFIND", path, nil, 0, map[string]string{"Depth": depth})
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+
+	if res.StatusCode != 207 {
+		return nil, fmt.Errorf("Properties of %s couldn't be read. System response: %s", path, res.Status)
+	}
+
+	result := davMultistatus{}
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Properties of %s couldn't be read.", path)
+	}
+
+	files := map[string]os.FileInfo{}
+	for _, response := range result.Responses {
+		// Hrefs may be full URLs or just paths.
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			continue
+		}
+
+		for _, propstat := range response.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+
+			prop := propstat.Prop
+			info := &fileInfo{
+				name: remotepath.Base(href.Path),
+				size: prop.Length,
+				mode: 0644,
+			}
+
+			info.modtime, _ = http.ParseTime(prop.Modified)
+			if prop.Type.Collection != nil {
+				info.mode = os.ModeDir | 0755
+			}
+
+			files[strings.TrimRight(href.Path, "/")] = info
+		}
+	}
+
+	return files, nil
+}
+
+// Send a request, authenticating when the server asks to. The
+// body is rewound when the request has to be sent again.
+func (w *webdav) request(method, path string, body io.ReadSeeker, size int64, headers map[string]string) (*http.Response, error) {
+	u := *w.base
+	u.Path = path
+	u.RawPath = escapePath(path)
+
+	for retry := 0; ; retry++ {
+		var reader io.Reader
+		if body != nil {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+
+			reader = body
+		}
+
+		req, err := http.NewRequest(method, u.String(), reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if body != nil {
+			req.ContentLength = size
+
+			// An unknown length would be sent chunked, which
+			// many servers don't accept.
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+		}
+
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		w.authorize(req)
+
+		res, err := w.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusUnauthorized || retry > 0 {
+			return res, nil
+		}
+
+		// Pick up the challenge and try once more. It also
+		// renews digest nonces that went stale.
+		res.Body.Close()
+		if !w.challenge(res.Header.Values("WWW-Authenticate")) {
+			return res, nil
+		}
+	}
+}
+
+// Read the authentication challenges of a 401 response.
+// Digest is preferred, as it doesn't send the password.
+func (w *webdav) challenge(headers []string) bool {
+	w.authlock.Lock()
+	defer w.authlock.Unlock()
+
+	auth := ""
+	for _, header := range headers {
+		scheme, params := parseChallenge(header)
+		switch {
+		case scheme == "digest" && digestHash(params["algorithm"]) != nil:
+			w.auth, w.digest, w.nc = "digest", params, 0
+			return true
+		case scheme == "basic":
+			auth = "basic"
+		}
+	}
+
+	if auth == "" {
+		return false
+	}
+
+	w.auth = auth
+
+	return true
+}
+
+// Add the authorization header of the current scheme.
+func (w *webdav) authorize(req *http.Request) {
+	w.authlock.Lock()
+	defer w.authlock.Unlock()
+
+	switch w.auth {
+	case "basic":
+		req.SetBasicAuth(w.username, w.password)
+	case "digest":
+		w.nc++
+		req.Header.Set("Authorization", w.digestResponse(req.Method, req.URL.RequestURI()))
+	}
+}
+
+// Build a digest authorization header.
+// Based on https://tools.ietf.org/html/rfc7616
+func (w *webdav) digestResponse(method, uri string) string {
+	h := digestHash(w.digest["algorithm"])
+	realm, nonce := w.digest["realm"], w.digest["nonce"]
+
+	cnonce := make([]byte, 8)
+	rand.Read(cnonce)
+	cn := hex.EncodeToString(cnonce)
+	nc := fmt.Sprintf("%08x", w.nc)
+
+	ha1 := digestSum(h, w.username+":"+realm+":"+w.password)
+	if strings.HasSuffix(strings.ToLower(w.digest["algorithm"]), "-sess") {
+		ha1 = digestSum(h, ha1+":"+nonce+":"+cn)
+	}
+
+	ha2 := digestSum(h, method+":"+uri)
+
+	qop := ""
+	for _, q := range strings.Split(w.digest["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, w.username, realm, nonce, uri)
+	if qop != "" {
+		response := digestSum(h, strings.Join([]string{ha1, nonce, nc, cn, qop, ha2}, ":"))
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, qop, nc, cn, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, digestSum(h, ha1+":"+nonce+":"+ha2))
+	}
+
+	if algorithm := w.digest["algorithm"]; algorithm != "" {
+		header += ", algorithm=" + algorithm
+	}
+
+	if opaque := w.digest["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+
+	return header
+}
+
+// Hash function of a digest algorithm, or nil when it isn't
+// supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+
+	return nil
+}
+
+// Hex encoded hash of a value.
+func digestSum(h func() hash.Hash, value string) string {
+	sum := h()
+	sum.Write([]byte(value))
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// Split a WWW-Authenticate header into its lowercase scheme
+// and parameters. Quoted values may contain commas.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+
+	header = strings.TrimSpace(header)
+	space := strings.IndexByte(header, ' ')
+	if space < 0 {
+		return strings.ToLower(header), params
+	}
+
+	scheme, rest := strings.ToLower(header[:space]), header[space+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimLeft(rest[eq+1:], " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return scheme, params
+}
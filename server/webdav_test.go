@@ -0,0 +1,254 @@
+package server
+
+import (
+	"testing"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// A minimal WebDAV stand-in that keeps files in memory and
+// checks either basic or digest credentials.
+type mockWebdav struct {
+	mutex  sync.Mutex
+	digest bool
+	files  map[string]string
+	dirs   map[string]bool
+}
+
+func newMockWebdav(digest bool) *mockWebdav {
+	return &mockWebdav{digest: digest, files: map[string]string{}, dirs: map[string]bool{"/": true, "/site": true}}
+}
+
+func (m *mockWebdav) authorized(r *http.Request) bool {
+	if !m.digest {
+		user, pass, ok := r.BasicAuth()
+		return ok && user == "user" && pass == "pass"
+	}
+
+	scheme, params := parseChallenge(r.Header.Get("Authorization"))
+	if scheme != "digest" {
+		return false
+	}
+
+	sum := func(value string) string {
+		h := md5.Sum([]byte(value))
+		return hex.EncodeToString(h[:])
+	}
+
+	ha1 := sum("user:dav:pass")
+	ha2 := sum(r.Method + ":" + params["uri"])
+	expected := sum(strings.Join([]string{ha1, "abc", params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+
+	return params["response"] == expected && params["uri"] == r.URL.RequestURI() && params["opaque"] == "xyz"
+}
+
+func (m *mockWebdav) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.authorized(r) {
+		if m.digest {
+			w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+			w.Header().Add("WWW-Authenticate", `Digest realm="dav", qop="auth,auth-int", nonce="abc", opaque="xyz"`)
+		} else {
+			w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimRight(r.URL.Path, "/")
+	parent := filepath.Dir(path)
+
+	switch r.Method {
+	case "PROPFIND":
+		if _, ok := m.files[path]; !ok && !m.dirs[path] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		members := []string{path}
+		if r.Header.Get("Depth") == "1" {
+			for name := range m.dirs {
+				if filepath.Dir(name) == path && name != path {
+					members = append(members, name)
+				}
+			}
+
+			for name := range m.files {
+				if filepath.Dir(name) == path {
+					members = append(members, name)
+				}
+			}
+		}
+
+		out := `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`
+		for _, name := range members {
+			prop := fmt.Sprintf("<d:getcontentlength>%d</d:getcontentlength><d:resourcetype/>", len(m.files[name]))
+			if m.dirs[name] {
+				name += "/"
+				prop = "<d:resourcetype><d:collection/></d:resourcetype>"
+			}
+
+			href := (&url.URL{Path: name}).EscapedPath()
+			out += fmt.Sprintf("<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>", href, prop)
+		}
+
+		w.WriteHeader(207)
+		w.Write([]byte(out + "</d:multistatus>"))
+	case "MOVE":
+		destination, _ := url.Parse(r.Header.Get("Destination"))
+		contents, ok := m.files[path]
+		if !ok || !m.dirs[filepath.Dir(destination.Path)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		delete(m.files, path)
+		m.files[destination.Path] = contents
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		if m.dirs[path] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !m.dirs[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		m.dirs[path] = true
+		w.WriteHeader(http.StatusCreated)
+	case "PUT":
+		if !m.dirs[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		m.files[path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		contents, ok := m.files[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(contents))
+	case "DELETE":
+		if m.dirs[path] {
+			delete(m.dirs, path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if _, ok := m.files[path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(m.files, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestWebdavDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steer-webdav")
+	if err != nil {
+		t.Fatalf("Temp directory couldn't be created.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "index.html")
+	ioutil.WriteFile(source, []byte("<h1>Hi</h1>"), 0644)
+
+	for _, digest := range []bool{false, true} {
+		mock := newMockWebdav(digest)
+		srv := httptest.NewServer(mock)
+		u, _ := url.Parse(srv.URL)
+
+		port := 0
+		fmt.Sscanf(u.Port(), "%d", &port)
+
+		driver, err := ConnectWebdav(Params{Scheme: "webdav", Host: u.Hostname(), Port: port, Username: "user", Password: "pass", Path: "/site"})
+		if err != nil {
+			t.Fatalf("Couldn't connect to WebDAV (digest: %v): %s", digest, err.Error())
+		}
+
+		if err = Manage(driver).Upload(source, "pages/my page.html"); err != nil {
+			t.Fatalf("File couldn't be uploaded (digest: %v): %s", digest, err.Error())
+		}
+
+		if mock.files["/site/pages/my page.html"] != "<h1>Hi</h1>" {
+			t.Fatalf("File wasn't stored at the expected path (digest: %v).", digest)
+		}
+
+		actual, err := Manage(driver).Read("pages/my page.html")
+		if err != nil || actual != "<h1>Hi</h1>" {
+			t.Fatalf("Expected <h1>Hi</h1> but got %s", actual)
+		}
+
+		if err = driver.Rename("pages/my page.html", "archive/my page.html"); err != nil {
+			t.Fatalf("File couldn't be renamed: %s", err.Error())
+		}
+
+		files, err := driver.List("")
+		if err != nil || len(files) != 2 {
+			t.Fatalf("Expected 2 directories, but got %v", files)
+		}
+
+		info, err := driver.Stat("archive/my page.html")
+		if err != nil || info.IsDir() || info.Size() != 11 || info.Name() != "my page.html" {
+			t.Fatalf("Expected details of my page.html, but got %v", info)
+		}
+
+		if err = driver.RemoveDir("archive"); err == nil {
+			t.Fatalf("Directory that isn't empty shouldn't be removed.")
+		}
+
+		if err = driver.RemoveDir("pages"); err != nil {
+			t.Fatalf("Directory couldn't be removed: %s", err.Error())
+		}
+
+		if err = driver.Delete("archive/my page.html"); err != nil {
+			t.Fatalf("File couldn't be deleted: %s", err.Error())
+		}
+
+		if _, err = Manage(driver).Read("archive/my page.html"); err == nil {
+			t.Fatalf("File should have been deleted.")
+		}
+
+		driver.Close()
+		srv.Close()
+	}
+}
+
+func TestWebdavWrongCredentials(t *testing.T) {
+	srv := httptest.NewServer(newMockWebdav(true))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port := 0
+	fmt.Sscanf(u.Port(), "%d", &port)
+
+	if _, err := ConnectWebdav(Params{Scheme: "webdav", Host: u.Hostname(), Port: port, Username: "user", Password: "wrong", Path: "/site"}); err == nil {
+		t.Fatalf("Connection with wrong credentials should fail.")
+	}
+
+	if _, err := ConnectWebdav(Params{Scheme: "webdav", Host: u.Hostname(), Port: port, Username: "user", Password: "pass", Path: "/missing"}); err == nil {
+		t.Fatalf("Connection to a missing path should fail.")
+	}
+}